@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+// backfillCheckpointKey keeps the progress of a backfill apart from the one of the regular monitoring.
+func backfillCheckpointKey(contentType string) string {
+	return "backfill-" + contentType
+}
+
+// runBackfill processes the [from, to) window chunk by chunk; the end of every processed chunk is checkpointed along
+// with the window, so that an interrupted backfill is resumed from the last processed chunk when it is run again over
+// the very same window. A checkpoint saved by a backfill over any other window is ignored.
+func runBackfill(ctx context.Context, s AnnotationsMonitoringService, from, to time.Time, chunk time.Duration) error {
+	if !from.Before(to) {
+		return fmt.Errorf("the backfill window is empty: %s - %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if chunk <= 0 {
+		return errors.New("the backfill chunk must be positive")
+	}
+
+	key := backfillCheckpointKey(s.contentType.name)
+	window := backfillWindow{From: from, To: to}
+	start := from
+	if cp, found := s.loadBackfillCheckpoint(key); found {
+		if cp.Backfill != nil && cp.Backfill.From.Equal(from) && cp.Backfill.To.Equal(to) && cp.WindowEnd.After(from) && !cp.WindowEnd.After(to) {
+			start = cp.WindowEnd
+			s.closedTxs.restore(cp.ClosedTransactions)
+			s.closedTxs.expire(time.Now())
+			logger.Infof(map[string]interface{}{
+				"content_type": s.contentType.name,
+				"chunk_start":  start.Format(time.RFC3339),
+			}, "Backfill is resumed from the last processed chunk.")
+		} else {
+			logger.Infof(map[string]interface{}{
+				"content_type": s.contentType.name,
+			}, "Backfill checkpoint has been saved for another window, it is ignored.")
+		}
+	}
+
+	var total cycleSummary
+	for start.Before(to) {
+		end := start.Add(chunk)
+		if end.After(to) {
+			end = to
+		}
+
+		summary := s.CloseTransactionsBetween(ctx, start, end)
+		total.Transactions += summary.Transactions
+		total.Closed += summary.Closed
+
+		if summary.Failed {
+			return fmt.Errorf("backfill of %s has failed on the chunk %s - %s", s.contentType.name, start.Format(time.RFC3339), end.Format(time.RFC3339))
+		}
+		if summary.Cancelled {
+			// the chunk will be processed again, but the transactions closed so far won't be
+			s.saveBackfillCheckpoint(key, window, start)
+			return ctx.Err()
+		}
+
+		s.saveBackfillCheckpoint(key, window, end)
+		logger.Infof(map[string]interface{}{
+			"content_type": s.contentType.name,
+			"chunk_start":  start.Format(time.RFC3339),
+			"chunk_end":    end.Format(time.RFC3339),
+			"transactions": summary.Transactions,
+			"closed":       summary.Closed,
+			"progress":     fmt.Sprintf("%.1f%%", 100*end.Sub(from).Seconds()/to.Sub(from).Seconds()),
+		}, "Backfill chunk has been processed.")
+		start = end
+	}
+
+	logger.Infof(map[string]interface{}{
+		"content_type": s.contentType.name,
+		"from":         from.Format(time.RFC3339),
+		"to":           to.Format(time.RFC3339),
+		"transactions": total.Transactions,
+		"closed":       total.Closed,
+	}, "Backfill has finished.")
+	return nil
+}
+
+func (s AnnotationsMonitoringService) loadBackfillCheckpoint(key string) (checkpoint, bool) {
+	if s.checkpoints == nil {
+		return checkpoint{}, false
+	}
+
+	cp, found, err := s.checkpoints.Load(key)
+	if err != nil {
+		logger.Errorf(map[string]interface{}{
+			"content_type": s.contentType.name,
+		}, err, "Loading the backfill checkpoint has failed.")
+		return checkpoint{}, false
+	}
+	return cp, found
+}
+
+// saveBackfillCheckpoint records the end of the last processed chunk of the window; nothing is saved in dry-run.
+func (s AnnotationsMonitoringService) saveBackfillCheckpoint(key string, window backfillWindow, chunkEnd time.Time) {
+	if s.checkpoints == nil || s.dryRun {
+		return
+	}
+
+	cp := checkpoint{
+		WindowEnd:          chunkEnd,
+		ClosedTransactions: s.closedTxs.snapshot(),
+		Backfill:           &window,
+	}
+
+	if err := s.checkpoints.Save(key, cp); err != nil {
+		logger.Errorf(map[string]interface{}{
+			"content_type": s.contentType.name,
+		}, err, "Saving the backfill checkpoint has failed.")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newBackfillTestService(t *testing.T, readerMock *eventReaderMock) (AnnotationsMonitoringService, CheckpointStore) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	store, err := newFileCheckpointStore(dir)
+	assert.NoError(t, err)
+
+	return AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 60,
+		checkpoints:               store,
+		closedTxs:                 newClosedTransactionIndex(time.Hour),
+		sink:                      loggerSink{},
+	}, store
+}
+
+// sameTime matches the times regardless of their location and monotonic clock reading, which are lost by the checkpoints.
+func sameTime(expected time.Time) interface{} {
+	return mock.MatchedBy(func(actual time.Time) bool { return actual.Equal(expected) })
+}
+
+func Test_runBackfill(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	from := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	to := from.Add(90 * time.Minute)
+
+	txs := transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
+			}}}
+
+	var readerMock = new(eventReaderMock)
+	// the transaction spans both chunks: it is only closed once
+	readerMock.On("GetTransactionsBetween", strings.ToLower(annotationsContentType), sameTime(from), sameTime(from.Add(time.Hour))).
+		Return(txs, nil).
+		On("GetTransactionsBetween", strings.ToLower(annotationsContentType), sameTime(from.Add(time.Hour)), sameTime(to)).
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, mock.AnythingOfType("string")).
+		Return(transactions{}, nil)
+
+	am, store := newBackfillTestService(t, readerMock)
+	err := runBackfill(context.Background(), am, from, to, time.Hour)
+
+	assert.NoError(t, err)
+	readerMock.AssertExpectations(t)
+
+	var closed, chunks int
+	for _, entry := range hook.AllEntries() {
+		switch entry.Message {
+		case "Transaction has finished":
+			closed++
+		case "Backfill chunk has been processed.":
+			chunks++
+		}
+	}
+	assert.Equal(t, 1, closed)
+	assert.Equal(t, 2, chunks)
+
+	assert.Equal(t, "Backfill has finished.", hook.LastEntry().Message)
+	assert.Equal(t, 2, hook.LastEntry().Data["transactions"])
+	assert.Equal(t, 1, hook.LastEntry().Data["closed"])
+
+	cp, found, err := store.Load(backfillCheckpointKey(annotationsContentType))
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, cp.WindowEnd.Equal(to))
+	assert.Contains(t, cp.ClosedTransactions, "tid1")
+	assert.True(t, cp.Backfill.From.Equal(from))
+	assert.True(t, cp.Backfill.To.Equal(to))
+
+	// the checkpoint of the regular monitoring is left alone
+	_, found, err = store.Load(annotationsContentType)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func Test_runBackfill_SplunkTransactionAcrossChunks(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	from := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	to := from.Add(2 * time.Hour)
+	at := func(d time.Duration) string { return from.Add(d).UTC().Format(defaultTimestampFormat) }
+
+	// the transaction starts in the first chunk and completes in the second one
+	tx := transactionEvent{TransactionID: "tid1", UUID: "uuid1", Events: []publishEvent{
+		{ContentType: annotationsContentType, Time: at(55 * time.Minute), Event: startEvent},
+		{ContentType: annotationsContentType, Time: at(65 * time.Minute), IsValid: "true", Event: mapperEvent},
+		{ContentType: annotationsContentType, Time: at(66 * time.Minute), Event: completenessCriteriaEvent, Level: infoLevel},
+	}}
+
+	// like Splunk, the event reader filters the events, not the transactions, by time
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/annotations/transactions", r.URL.Path)
+		period, err := time.ParseDuration(strings.TrimPrefix(r.URL.Query().Get(earliestTimePathVar), "-"))
+		assert.NoError(t, err)
+
+		var events []publishEvent
+		for _, event := range tx.Events {
+			if !event.parsedTime().Before(time.Now().Add(-period)) {
+				events = append(events, event)
+			}
+		}
+
+		txs := transactions{}
+		if len(events) > 0 {
+			txs = append(txs, transactionEvent{TransactionID: tx.TransactionID, UUID: tx.UUID, Events: events})
+		}
+		json.NewEncoder(w).Encode(txs)
+	}))
+	defer eventReaderServer.Close()
+
+	am, _ := newBackfillTestService(t, nil)
+	am.eventReader = SplunkEventReader{eventReaderAddress: eventReaderServer.URL}
+	sink := &recordingSink{}
+	am.sink = sink
+
+	err := runBackfill(context.Background(), am, from, to, time.Hour)
+
+	assert.NoError(t, err)
+	if assert.Len(t, sink.records, 1) {
+		assert.Equal(t, "tid1", sink.records[0].TransactionID)
+		assert.Equal(t, "completed", sink.records[0].Result)
+		assert.Equal(t, at(66*time.Minute), sink.records[0].EndTime)
+	}
+}
+
+func Test_runBackfill_ResumesFromLastChunk(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	from := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	to := from.Add(2 * time.Hour)
+
+	var readerMock = new(eventReaderMock)
+	readerMock.On("GetTransactionsBetween", strings.ToLower(annotationsContentType), sameTime(from.Add(time.Hour)), sameTime(to)).
+		Return(transactions{}, nil)
+
+	am, store := newBackfillTestService(t, readerMock)
+	assert.NoError(t, store.Save(backfillCheckpointKey(annotationsContentType), checkpoint{
+		WindowEnd: from.Add(time.Hour),
+		Backfill:  &backfillWindow{From: from, To: to},
+	}))
+
+	err := runBackfill(context.Background(), am, from, to, time.Hour)
+
+	assert.NoError(t, err)
+	readerMock.AssertExpectations(t)
+	readerMock.AssertNumberOfCalls(t, "GetTransactionsBetween", 1)
+}
+
+func Test_runBackfill_IgnoresCheckpointOfAnotherWindow(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	from := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	to := from.Add(2 * time.Hour)
+
+	var readerMock = new(eventReaderMock)
+	readerMock.On("GetTransactionsBetween", strings.ToLower(annotationsContentType), sameTime(from), sameTime(from.Add(time.Hour))).
+		Return(transactions{}, nil).
+		On("GetTransactionsBetween", strings.ToLower(annotationsContentType), sameTime(from.Add(time.Hour)), sameTime(to)).
+		Return(transactions{}, nil)
+
+	am, store := newBackfillTestService(t, readerMock)
+	for _, cp := range []checkpoint{
+		// an earlier backfill, whose window ends within this one
+		{WindowEnd: from.Add(time.Hour), Backfill: &backfillWindow{From: from.Add(-time.Hour), To: from.Add(time.Hour)}},
+		// a checkpoint that doesn't record its window
+		{WindowEnd: from.Add(time.Hour)},
+	} {
+		assert.NoError(t, store.Save(backfillCheckpointKey(annotationsContentType), cp))
+
+		err := runBackfill(context.Background(), am, from, to, time.Hour)
+		assert.NoError(t, err)
+	}
+
+	readerMock.AssertExpectations(t)
+	readerMock.AssertNumberOfCalls(t, "GetTransactionsBetween", 4)
+}
+
+func Test_runBackfill_Failed(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	from := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	to := from.Add(2 * time.Hour)
+
+	var readerMock = new(eventReaderMock)
+	readerMock.On("GetTransactionsBetween", strings.ToLower(annotationsContentType), sameTime(from), sameTime(from.Add(time.Hour))).
+		Return(transactions{}, errors.New("event reader is unavailable"))
+
+	am, store := newBackfillTestService(t, readerMock)
+	err := runBackfill(context.Background(), am, from, to, time.Hour)
+
+	assert.Error(t, err)
+	readerMock.AssertNumberOfCalls(t, "GetTransactionsBetween", 1)
+
+	// the failed chunk is processed again on the next run
+	_, found, err := store.Load(backfillCheckpointKey(annotationsContentType))
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func Test_runBackfill_InvalidWindow(t *testing.T) {
+	am, _ := newBackfillTestService(t, new(eventReaderMock))
+	now := time.Now()
+
+	assert.Error(t, runBackfill(context.Background(), am, now, now.Add(-time.Hour), time.Hour))
+	assert.Error(t, runBackfill(context.Background(), am, now.Add(-time.Hour), now, 0))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	fileCheckpointStoreType = "file"
+	boltCheckpointStoreType = "bolt"
+
+	checkpointsBucket = "checkpoints"
+)
+
+// checkpoint records how far the monitoring of a content type has got.
+type checkpoint struct {
+	// end of the last window whose transactions have all been processed
+	WindowEnd time.Time `json:"windowEnd"`
+	// transactions that have already been closed, with the time they have been closed at
+	ClosedTransactions map[string]time.Time `json:"closedTransactions,omitempty"`
+	// window of the backfill that has saved the checkpoint; nil for the regular monitoring
+	Backfill *backfillWindow `json:"backfill,omitempty"`
+}
+
+type backfillWindow struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// CheckpointStore persists the monitoring progress, so that the monitoring can be resumed after a restart.
+type CheckpointStore interface {
+	// Load returns the checkpoint saved for the given key, and whether there was one
+	Load(key string) (checkpoint, bool, error)
+	Save(key string, cp checkpoint) error
+	Close() error
+}
+
+// newCheckpointStore creates a checkpoint store of the given type; no store is created when the type is empty.
+func newCheckpointStore(storeType, path string) (CheckpointStore, error) {
+	switch storeType {
+	case "":
+		return nil, nil
+	case fileCheckpointStoreType:
+		store, err := newFileCheckpointStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case boltCheckpointStoreType:
+		store, err := newBoltCheckpointStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown checkpoint store type: %s", storeType)
+	}
+}
+
+// fileCheckpointStore keeps every checkpoint in its own JSON file, within the given directory.
+type fileCheckpointStore struct {
+	dir string
+}
+
+func newFileCheckpointStore(dir string) (*fileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileCheckpointStore{dir: dir}, nil
+}
+
+func (s *fileCheckpointStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s *fileCheckpointStore) Load(key string) (checkpoint, bool, error) {
+	b, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return checkpoint{}, false, nil
+	}
+	if err != nil {
+		return checkpoint{}, false, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return checkpoint{}, false, err
+	}
+	return cp, true, nil
+}
+
+func (s *fileCheckpointStore) Save(key string, cp checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that a crash doesn't leave a partially written checkpoint behind
+	tmp, err := ioutil.TempFile(s.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *fileCheckpointStore) Close() error {
+	return nil
+}
+
+// boltCheckpointStore keeps the checkpoints in a BoltDB database file.
+type boltCheckpointStore struct {
+	db *bolt.DB
+}
+
+func newBoltCheckpointStore(path string) (*boltCheckpointStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(checkpointsBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltCheckpointStore{db: db}, nil
+}
+
+func (s *boltCheckpointStore) Load(key string) (checkpoint, bool, error) {
+	var cp checkpoint
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(checkpointsBucket)).Get([]byte(key))
+		if b == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(b, &cp)
+	})
+	if err != nil {
+		return checkpoint{}, false, err
+	}
+	return cp, found, nil
+}
+
+func (s *boltCheckpointStore) Save(key string, cp checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(checkpointsBucket)).Put([]byte(key), b)
+	})
+}
+
+func (s *boltCheckpointStore) Close() error {
+	return s.db.Close()
+}
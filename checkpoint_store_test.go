@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckpointStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileStore, err := newCheckpointStore(fileCheckpointStoreType, filepath.Join(dir, "files"))
+	assert.NoError(t, err)
+	boltStore, err := newCheckpointStore(boltCheckpointStoreType, filepath.Join(dir, "checkpoints.db"))
+	assert.NoError(t, err)
+
+	for _, store := range []CheckpointStore{fileStore, boltStore} {
+		_, found, err := store.Load(annotationsContentType)
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		windowEnd := time.Date(2017, 9, 22, 11, 45, 0, 0, time.UTC)
+		cp := checkpoint{
+			WindowEnd:          windowEnd,
+			ClosedTransactions: map[string]time.Time{"tid1": windowEnd},
+		}
+		assert.NoError(t, store.Save(annotationsContentType, cp))
+
+		loaded, found, err := store.Load(annotationsContentType)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.True(t, windowEnd.Equal(loaded.WindowEnd))
+		assert.Equal(t, 1, len(loaded.ClosedTransactions))
+		assert.True(t, windowEnd.Equal(loaded.ClosedTransactions["tid1"]))
+
+		// other keys are unaffected
+		_, found, err = store.Load(conceptsContentType)
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		assert.NoError(t, store.Close())
+	}
+
+	// the bolt store persists the checkpoints after it has been reopened
+	boltStore, err = newCheckpointStore(boltCheckpointStoreType, filepath.Join(dir, "checkpoints.db"))
+	assert.NoError(t, err)
+	_, found, err := boltStore.Load(annotationsContentType)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	boltStore.Close()
+}
+
+func Test_newCheckpointStore(t *testing.T) {
+	store, err := newCheckpointStore("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	_, err = newCheckpointStore("redis", "")
+	assert.EqualError(t, err, "unknown checkpoint store type: redis")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+var errCircuitOpen = errors.New("the event reader circuit is open")
+
+// circuitBreaker stops calling the event reader once it has failed failureThreshold times in a row; after the cooldown,
+// a single probe call is let through, which closes the circuit if it succeeds and opens it again otherwise.
+type circuitBreaker struct {
+	failureThreshold int
+	cooldown         time.Duration
+
+	sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+}
+
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{failureThreshold: failureThreshold, cooldown: cooldown, state: circuitClosed}
+	recordCircuitState(circuitClosed)
+	return b
+}
+
+// allow tells whether a call can be made; when the cooldown is over, the call is the probe of the half-open circuit.
+func (b *circuitBreaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case circuitClosed:
+		return true
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		return true
+	default:
+		// the probe is still in flight
+		return false
+	}
+}
+
+// isOpen tells whether the calls are currently rejected, and will be until the cooldown is over.
+func (b *circuitBreaker) isOpen() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.state == circuitOpen && time.Since(b.openedAt) < b.cooldown
+}
+
+// record counts the outcome of an allowed call; the calls cancelled by the caller are not counted.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if ctx.Err() != nil {
+		// a cancelled probe is replaced by the next call
+		if b.state == circuitHalfOpen {
+			b.setState(circuitOpen)
+		}
+		return
+	}
+
+	if err == nil || errors.Is(err, errTransactionNotFound) || errors.Is(err, errClosedTransactionsUnavailable) {
+		b.consecutiveFailures = 0
+		if b.state != circuitClosed {
+			logger.Infof(nil, "Event reader has recovered, its circuit is closed.")
+			b.setState(circuitClosed)
+		}
+		return
+	}
+
+	b.consecutiveFailures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.consecutiveFailures >= b.failureThreshold) {
+		if b.state == circuitClosed {
+			logger.Errorf(map[string]interface{}{
+				"consecutive_failures": b.consecutiveFailures,
+				"cooldown":             b.cooldown.String(),
+			}, err, "Event reader keeps failing, its circuit is open.")
+		}
+		b.openedAt = time.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	recordCircuitState(state)
+}
+
+// status returns the state of the circuit, and the number of consecutive failures.
+func (b *circuitBreaker) status() (string, int) {
+	b.Lock()
+	defer b.Unlock()
+	return b.state, b.consecutiveFailures
+}
+
+// circuitBreakerEventReader guards the calls to an event reader with a circuit breaker.
+type circuitBreakerEventReader struct {
+	next    EventReader
+	breaker *circuitBreaker
+}
+
+func (r circuitBreakerEventReader) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
+	if !r.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	txs, err := r.next.GetTransactions(ctx, contentType, lookbackPeriod)
+	r.breaker.record(ctx, err)
+	return txs, err
+}
+
+// StreamTransactions streams the transactions if the guarded event reader can, and hands them over one at a time otherwise.
+func (r circuitBreakerEventReader) StreamTransactions(ctx context.Context, contentType string, lookbackPeriod string, handle func(tx transactionEvent)) error {
+	streamer, ok := r.next.(TransactionStreamer)
+	if !ok {
+		txs, err := r.GetTransactions(ctx, contentType, lookbackPeriod)
+		for _, tx := range txs {
+			handle(tx)
+		}
+		return err
+	}
+
+	if !r.breaker.allow() {
+		return errCircuitOpen
+	}
+	err := streamer.StreamTransactions(ctx, contentType, lookbackPeriod, handle)
+	r.breaker.record(ctx, err)
+	return err
+}
+
+// StreamTransactionsForUUIDs streams the transactions of the contents in the same way as StreamTransactions.
+func (r circuitBreakerEventReader) StreamTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string, handle func(tx transactionEvent)) error {
+	streamer, ok := r.next.(TransactionStreamer)
+	if !ok {
+		txs, err := r.GetTransactionsForUUIDs(ctx, contentType, uuids, lookbackPeriod)
+		for _, tx := range txs {
+			handle(tx)
+		}
+		return err
+	}
+
+	if !r.breaker.allow() {
+		return errCircuitOpen
+	}
+	err := streamer.StreamTransactionsForUUIDs(ctx, contentType, uuids, lookbackPeriod, handle)
+	r.breaker.record(ctx, err)
+	return err
+}
+
+func (r circuitBreakerEventReader) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	if !r.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	txs, err := r.next.GetTransactionsForUUIDs(ctx, contentType, uuids, lookbackPeriod)
+	r.breaker.record(ctx, err)
+	return txs, err
+}
+
+func (r circuitBreakerEventReader) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	if !r.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	txs, err := r.next.GetAllTransactionsForUUIDs(ctx, contentType, uuids, lookbackPeriod)
+	r.breaker.record(ctx, err)
+	return txs, err
+}
+
+func (r circuitBreakerEventReader) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	if !r.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	txs, err := r.next.GetTransactionsBetween(ctx, contentType, earliest, latest)
+	r.breaker.record(ctx, err)
+	return txs, err
+}
+
+func (r circuitBreakerEventReader) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
+	if !r.breaker.allow() {
+		return publishEvent{}, errCircuitOpen
+	}
+	event, err := r.next.GetLatestEvent(ctx, contentType, lookbackPeriod)
+	r.breaker.record(ctx, err)
+	return event, err
+}
+
+func (r circuitBreakerEventReader) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	if !r.breaker.allow() {
+		return transactionEvent{}, errCircuitOpen
+	}
+	tx, err := r.next.GetTransaction(ctx, contentType, transactionID, lookbackPeriod)
+	r.breaker.record(ctx, err)
+	return tx, err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+	ctx := context.Background()
+	failure := errors.New("event reader is down")
+
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+
+	// not found isn't a failure of the event reader
+	b.record(ctx, failure)
+	b.record(ctx, errTransactionNotFound)
+	b.record(ctx, failure)
+	assert.True(t, b.allow())
+	assert.False(t, b.isOpen())
+
+	b.record(ctx, failure)
+	state, failures := b.status()
+	assert.Equal(t, circuitOpen, state)
+	assert.Equal(t, 2, failures)
+	assert.True(t, b.isOpen())
+	assert.False(t, b.allow())
+	assert.Equal(t, 1.0, testutil.ToFloat64(eventReaderCircuitState.WithLabelValues(circuitOpen)))
+
+	// a single probe is let through after the cooldown; its failure opens the circuit again
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, b.isOpen())
+	assert.True(t, b.allow())
+	assert.False(t, b.allow())
+	assert.Equal(t, 1.0, testutil.ToFloat64(eventReaderCircuitState.WithLabelValues(circuitHalfOpen)))
+	b.record(ctx, failure)
+	assert.True(t, b.isOpen())
+
+	// a cancelled probe is replaced by the next call
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, b.allow())
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.record(cancelled, context.Canceled)
+	assert.True(t, b.allow())
+
+	b.record(ctx, nil)
+	state, failures = b.status()
+	assert.Equal(t, circuitClosed, state)
+	assert.Equal(t, 0, failures)
+	assert.True(t, b.allow())
+	assert.Equal(t, 1.0, testutil.ToFloat64(eventReaderCircuitState.WithLabelValues(circuitClosed)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(eventReaderCircuitState.WithLabelValues(circuitOpen)))
+}
+
+func Test_circuitBreakerEventReader(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	var readerMock = new(eventReaderMock)
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), "60m").
+		Return(publishEvent{}, errors.New("event reader is down"))
+
+	reader := circuitBreakerEventReader{next: readerMock, breaker: newCircuitBreaker(2, time.Hour)}
+
+	for i := 0; i < 3; i++ {
+		_, err := reader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
+		assert.Error(t, err)
+	}
+
+	_, err := reader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
+	assert.Equal(t, errCircuitOpen, err)
+	readerMock.AssertNumberOfCalls(t, "GetLatestEvent", 2)
+	readerMock.AssertNotCalled(t, "GetTransactions", mock.Anything, mock.Anything)
+}
+
+func Test_CloseCompletedTransactions_CircuitOpen(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	breaker := newCircuitBreaker(1, time.Hour)
+	breaker.record(context.Background(), errors.New("event reader is down"))
+	hook.Reset()
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               circuitBreakerEventReader{next: readerMock, breaker: breaker},
+		contentType:               contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod:         4320,
+		supersededCheckbackPeriod: 60,
+		eventReaderCircuit:        breaker,
+	}
+
+	summary := am.CloseCompletedTransactions(context.Background())
+
+	assert.True(t, summary.Skipped)
+	assert.True(t, summary.Failed)
+	assert.Empty(t, readerMock.Calls)
+
+	assert.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, "warning", hook.LastEntry().Level.String())
+	assert.Equal(t, "Monitoring transactions has been skipped, the event reader circuit is open.", hook.LastEntry().Message)
+	assert.Equal(t, annotationsContentType, hook.LastEntry().Data["content_type"])
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// closedTransactionIndex remembers the recently closed transactions, so that the overlapping lookback windows
+// (or an event reader that lags behind) don't lead to the same transaction being closed twice.
+// A nil index doesn't remember anything.
+type closedTransactionIndex struct {
+	sync.Mutex
+	ttl        time.Duration
+	closed     map[string]time.Time
+	duplicates int
+}
+
+func newClosedTransactionIndex(ttl time.Duration) *closedTransactionIndex {
+	return &closedTransactionIndex{
+		ttl:    ttl,
+		closed: make(map[string]time.Time),
+	}
+}
+
+// isDuplicate checks whether the transaction has already been closed; if so, the closure is counted as a suppressed duplicate.
+func (i *closedTransactionIndex) isDuplicate(tid string) bool {
+	if i == nil {
+		return false
+	}
+
+	i.Lock()
+	defer i.Unlock()
+
+	if _, found := i.closed[tid]; !found {
+		return false
+	}
+	i.duplicates++
+	return true
+}
+
+func (i *closedTransactionIndex) markClosed(tid string, closedAt time.Time) {
+	if i == nil {
+		return
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	i.closed[tid] = closedAt
+}
+
+// expire forgets the transactions that have been closed for longer than the TTL.
+func (i *closedTransactionIndex) expire(now time.Time) {
+	if i == nil {
+		return
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	for tid, closedAt := range i.closed {
+		if now.Sub(closedAt) > i.ttl {
+			delete(i.closed, tid)
+		}
+	}
+}
+
+// restore adds previously persisted closures to the index.
+func (i *closedTransactionIndex) restore(closed map[string]time.Time) {
+	if i == nil {
+		return
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	for tid, closedAt := range closed {
+		i.closed[tid] = closedAt
+	}
+}
+
+// snapshot returns a copy of the closures, so that they can be persisted.
+func (i *closedTransactionIndex) snapshot() map[string]time.Time {
+	if i == nil {
+		return nil
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	result := make(map[string]time.Time, len(i.closed))
+	for tid, closedAt := range i.closed {
+		result[tid] = closedAt
+	}
+	return result
+}
+
+// suppressedDuplicates returns how many closures have been suppressed since the index has been created.
+func (i *closedTransactionIndex) suppressedDuplicates() int {
+	if i == nil {
+		return 0
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	return i.duplicates
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_closedTransactionIndex(t *testing.T) {
+	now := time.Now()
+	index := newClosedTransactionIndex(time.Hour)
+
+	assert.False(t, index.isDuplicate("tid1"))
+	index.markClosed("tid1", now.Add(-2*time.Hour))
+	index.markClosed("tid2", now.Add(-30*time.Minute))
+
+	assert.True(t, index.isDuplicate("tid1"))
+	assert.True(t, index.isDuplicate("tid2"))
+	assert.Equal(t, 2, index.suppressedDuplicates())
+
+	index.expire(now)
+	assert.False(t, index.isDuplicate("tid1"))
+	assert.True(t, index.isDuplicate("tid2"))
+	assert.Equal(t, 3, index.suppressedDuplicates())
+
+	snapshot := index.snapshot()
+	assert.Equal(t, map[string]time.Time{"tid2": now.Add(-30 * time.Minute)}, snapshot)
+
+	restored := newClosedTransactionIndex(time.Hour)
+	restored.restore(snapshot)
+	assert.True(t, restored.isDuplicate("tid2"))
+}
+
+func Test_closedTransactionIndex_Nil(t *testing.T) {
+	var index *closedTransactionIndex
+
+	index.markClosed("tid1", time.Now())
+	index.restore(map[string]time.Time{"tid1": time.Now()})
+	index.expire(time.Now())
+
+	assert.False(t, index.isDuplicate("tid1"))
+	assert.Nil(t, index.snapshot())
+	assert.Equal(t, 0, index.suppressedDuplicates())
+}
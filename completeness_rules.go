@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// completenessRule describes which events a transaction needs before it can be considered complete.
+// All the non-empty clauses have to be satisfied.
+type completenessRule struct {
+	Name string `json:"name"`
+	// every event has to be present
+	AllOf []eventMatcher `json:"allOf,omitempty"`
+	// at least one of the events has to be present
+	AnyOf []eventMatcher `json:"anyOf,omitempty"`
+	// the events have to be present in this order
+	Sequence []eventMatcher `json:"sequence,omitempty"`
+}
+
+// eventMatcher matches publish events by event name and, optionally, by log level.
+type eventMatcher struct {
+	Event string `json:"event"`
+	Level string `json:"level,omitempty"`
+}
+
+// defaultCompletenessRule considers a transaction complete when it has been successfully written to Neo4j.
+var defaultCompletenessRule = completenessRule{
+	Name:  "default",
+	AllOf: []eventMatcher{{Event: completenessCriteriaEvent, Level: infoLevel}},
+}
+
+func (m eventMatcher) matches(event publishEvent) bool {
+	return event.Event == m.Event && (m.Level == "" || event.Level == m.Level)
+}
+
+// match checks the events against the rule; if the rule is satisfied, it returns the time of the event that completed the transaction.
+func (r completenessRule) match(events []publishEvent) (endTime string, complete bool) {
+
+	// events are not guaranteed to be in chronological order
+	sorted := make([]publishEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].parsedTime().Before(sorted[j].parsedTime())
+	})
+
+	var end publishEvent
+	for _, clause := range []func([]publishEvent) (publishEvent, bool){r.matchAllOf, r.matchAnyOf, r.matchSequence} {
+		e, ok := clause(sorted)
+		if !ok {
+			return "", false
+		}
+		if later(e, end) {
+			end = e
+		}
+	}
+
+	return end.Time, end.Time != ""
+}
+
+// later tells whether the event has been logged after the other one; an event without a time is never later.
+func later(event, other publishEvent) bool {
+	return event.Time != "" && (other.Time == "" || event.parsedTime().After(other.parsedTime()))
+}
+
+func (r completenessRule) matchAllOf(events []publishEvent) (publishEvent, bool) {
+	var end publishEvent
+	for _, m := range r.AllOf {
+		e, _, found := firstMatch(events, m, 0)
+		if !found {
+			return publishEvent{}, false
+		}
+		if later(e, end) {
+			end = e
+		}
+	}
+	return end, true
+}
+
+func (r completenessRule) matchAnyOf(events []publishEvent) (publishEvent, bool) {
+	if len(r.AnyOf) == 0 {
+		return publishEvent{}, true
+	}
+
+	var end publishEvent
+	for _, m := range r.AnyOf {
+		if e, _, found := firstMatch(events, m, 0); found && e.Time != "" && (end.Time == "" || later(end, e)) {
+			end = e
+		}
+	}
+	return end, end.Time != ""
+}
+
+func (r completenessRule) matchSequence(events []publishEvent) (publishEvent, bool) {
+	var end publishEvent
+	// every step has to be matched by a later event than the previous one
+	next := 0
+	for _, m := range r.Sequence {
+		e, i, found := firstMatch(events, m, next)
+		if !found {
+			return publishEvent{}, false
+		}
+		end, next = e, i+1
+	}
+	return end, true
+}
+
+// firstMatch returns the first matching event and its index, starting from the given index.
+func firstMatch(events []publishEvent, m eventMatcher, from int) (publishEvent, int, bool) {
+	for i := from; i < len(events); i++ {
+		if m.matches(events[i]) {
+			return events[i], i, true
+		}
+	}
+	return publishEvent{}, 0, false
+}
+
+func (r completenessRule) validate() error {
+	if len(r.AllOf) == 0 && len(r.AnyOf) == 0 && len(r.Sequence) == 0 {
+		return fmt.Errorf("completeness rule %q has no conditions", r.Name)
+	}
+	for _, clause := range [][]eventMatcher{r.AllOf, r.AnyOf, r.Sequence} {
+		for _, m := range clause {
+			if m.Event == "" {
+				return fmt.Errorf("completeness rule %q has a condition with no event name", r.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// loadCompletenessRules reads the completeness rules (a JSON object keyed by content type) from the given file,
+// and applies them to the matching content types. Content types without a rule keep their default one.
+func loadCompletenessRules(path string, contentTypes []contentTypeConfig) ([]contentTypeConfig, error) {
+	if path == "" {
+		return contentTypes, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules map[string]completenessRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, err
+	}
+
+	result := make([]contentTypeConfig, len(contentTypes))
+	copy(result, contentTypes)
+
+	for name, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = name
+		}
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+
+		applied := false
+		for i := range result {
+			if strings.EqualFold(result[i].name, name) {
+				result[i].completeness = rule
+				applied = true
+			}
+		}
+		if !applied {
+			return nil, errors.New("completeness rule defined for a content type that is not monitored: " + name)
+		}
+	}
+
+	return result, nil
+}
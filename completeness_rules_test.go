@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_completenessRule_match(t *testing.T) {
+	events := []publishEvent{
+		{Time: "2017-09-22T11:45:53.00000000Z", Event: "NotifyKafka", Level: "info"},
+		{Time: "2017-09-22T11:45:47.00000000Z", Event: startEvent, Level: "info"},
+		{Time: "2017-09-22T11:45:50.00000000Z", Event: "SaveNeo4j", Level: "info"},
+		{Time: "2017-09-22T11:45:51.00000000Z", Event: "SavePostgres", Level: "error"},
+		{Time: "2017-09-22T11:45:52.00000000Z", Event: "SavePostgres", Level: "info"},
+	}
+
+	var tests = []struct {
+		name     string
+		rule     completenessRule
+		endTime  string
+		complete bool
+	}{
+		{"default rule", defaultCompletenessRule, "2017-09-22T11:45:50.00000000Z", true},
+		{"all of, the latest event completes the transaction",
+			completenessRule{AllOf: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SavePostgres", Level: "info"}, {Event: "NotifyKafka"}}},
+			"2017-09-22T11:45:53.00000000Z", true},
+		{"all of, missing event",
+			completenessRule{AllOf: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SaveElasticsearch"}}},
+			"", false},
+		{"all of, level filter",
+			completenessRule{AllOf: []eventMatcher{{Event: "SavePostgres", Level: "error"}}},
+			"2017-09-22T11:45:51.00000000Z", true},
+		{"any of, the earliest event completes the transaction",
+			completenessRule{AnyOf: []eventMatcher{{Event: "SavePostgres", Level: "info"}, {Event: "SaveNeo4j"}}},
+			"2017-09-22T11:45:50.00000000Z", true},
+		{"any of, no event",
+			completenessRule{AnyOf: []eventMatcher{{Event: "SaveElasticsearch"}}},
+			"", false},
+		{"sequence in order",
+			completenessRule{Sequence: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SavePostgres", Level: "info"}, {Event: "NotifyKafka"}}},
+			"2017-09-22T11:45:53.00000000Z", true},
+		{"sequence out of order",
+			completenessRule{Sequence: []eventMatcher{{Event: "NotifyKafka"}, {Event: "SaveNeo4j"}}},
+			"", false},
+		{"sequence, an event matches a single step",
+			completenessRule{Sequence: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SaveNeo4j"}}},
+			"", false},
+		{"sequence, the same step logged twice",
+			completenessRule{Sequence: []eventMatcher{{Event: "SavePostgres"}, {Event: "SavePostgres"}}},
+			"2017-09-22T11:45:52.00000000Z", true},
+		{"combined clauses",
+			completenessRule{AllOf: []eventMatcher{{Event: "SaveNeo4j"}}, AnyOf: []eventMatcher{{Event: "SavePostgres", Level: "info"}}},
+			"2017-09-22T11:45:52.00000000Z", true},
+	}
+
+	for _, test := range tests {
+		endTime, complete := test.rule.match(events)
+		assert.Equal(t, test.endTime, endTime, test.name)
+		assert.Equal(t, test.complete, complete, test.name)
+	}
+}
+
+func Test_completenessRule_match_SameTime(t *testing.T) {
+	events := []publishEvent{
+		{Time: "2017-09-22T11:45:47.00000000Z", Event: startEvent},
+		{Time: "2017-09-22T11:45:50.00000000Z", Event: "SavePostgres"},
+		{Time: "2017-09-22T11:45:50.00000000Z", Event: "SaveNeo4j"},
+	}
+
+	// events logged at the same time are taken in the order they have been returned
+	_, complete := completenessRule{Sequence: []eventMatcher{{Event: "SavePostgres"}, {Event: "SaveNeo4j"}}}.match(events)
+	assert.True(t, complete)
+	_, complete = completenessRule{Sequence: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SavePostgres"}}}.match(events)
+	assert.False(t, complete)
+}
+
+func Test_completenessRule_match_TrimmedFraction(t *testing.T) {
+	// decoded as the event reader returns them, with the trailing zeros of the fraction trimmed
+	var events []publishEvent
+	err := json.Unmarshal([]byte(`[
+		{"@time": "2017-09-22T11:45:50.5Z", "event": "SavePostgres"},
+		{"@time": "2017-09-22T11:45:50Z", "event": "SaveNeo4j"}
+	]`), &events)
+	assert.NoError(t, err)
+
+	endTime, complete := completenessRule{Sequence: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SavePostgres"}}}.match(events)
+	assert.True(t, complete)
+	assert.Equal(t, "2017-09-22T11:45:50.5Z", endTime)
+
+	assert.Equal(t, "SavePostgres", latestEvent(transactionEvent{Events: events}).Event)
+}
+
+func Test_completenessRule_validate(t *testing.T) {
+	assert.NoError(t, defaultCompletenessRule.validate())
+	assert.EqualError(t, completenessRule{Name: "empty"}.validate(), `completeness rule "empty" has no conditions`)
+	assert.EqualError(t, completenessRule{Name: "noname", AnyOf: []eventMatcher{{Level: "info"}}}.validate(), `completeness rule "noname" has a condition with no event name`)
+}
+
+func Test_loadCompletenessRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cts := []contentTypeConfig{contentTypeRegistry[annotationsContentType], contentTypeRegistry[conceptsContentType]}
+
+	// no file: keep the defaults
+	result, err := loadCompletenessRules("", cts)
+	assert.NoError(t, err)
+	assert.Equal(t, cts, result)
+
+	path := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(path, []byte(`{"annotations": {"allOf": [{"event": "SaveNeo4j", "level": "info"}, {"event": "SavePostgres", "level": "info"}]}}`), 0644)
+	result, err = loadCompletenessRules(path, cts)
+	assert.NoError(t, err)
+	assert.Equal(t, "annotations", result[0].completeness.Name)
+	assert.Equal(t, 2, len(result[0].completeness.AllOf))
+	assert.Equal(t, defaultCompletenessRule, result[1].completeness)
+	// the registry is not modified
+	assert.Equal(t, defaultCompletenessRule, contentTypeRegistry[annotationsContentType].completeness)
+
+	ioutil.WriteFile(path, []byte(`{"Suggestions": {"anyOf": [{"event": "SaveNeo4j"}]}}`), 0644)
+	_, err = loadCompletenessRules(path, cts)
+	assert.EqualError(t, err, "completeness rule defined for a content type that is not monitored: Suggestions")
+
+	ioutil.WriteFile(path, []byte(`{"Annotations": {}}`), 0644)
+	_, err = loadCompletenessRules(path, cts)
+	assert.EqualError(t, err, `completeness rule "Annotations" has no conditions`)
+
+	ioutil.WriteFile(path, []byte(`not json`), 0644)
+	_, err = loadCompletenessRules(path, cts)
+	assert.Error(t, err)
+
+	_, err = loadCompletenessRules(filepath.Join(dir, "missing.json"), cts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/IBM/sarama"
+)
+
+const (
+	loggerSinkType  = "logger"
+	ndjsonSinkType  = "ndjson"
+	webhookSinkType = "webhook"
+	kafkaSinkType   = "kafka"
+)
+
+// completionRecord describes a closed transaction, i.e. the PublishEnd event logged for it.
+type completionRecord struct {
+	TransactionID string
+	UUID          string
+	ContentType   string
+	StartTime     string
+	EndTime       string
+	LogTime       string
+	Duration      time.Duration
+	// empty for superseded transactions, as they might have failed before reaching the validation
+	IsValid string
+	// result of the transaction: completed, invalid, superseded, failed or timeout
+	Result        string
+	Outcome       string
+	LastStage     string
+	FailedService string
+	ErrorMessage  string
+	SupersededBy  string
+	Message       string
+	// rule that has closed the transaction: the completeness rule of the content type, validation, superseded, timeout or pipeline error
+	Rule string
+}
+
+// fields returns the fields of the monitoring log event.
+func (r completionRecord) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"@time":                r.EndTime,
+		"logTime":              r.LogTime,
+		"event":                endEvent,
+		"transaction_id":       r.TransactionID,
+		"uuid":                 r.UUID,
+		"startTime":            r.StartTime,
+		"endTime":              r.EndTime,
+		"transaction_duration": fmt.Sprint(r.Duration.Seconds()),
+		"monitoring_event":     "true",
+		"content_type":         r.ContentType,
+	}
+
+	optional := map[string]string{
+		"isValid":       r.IsValid,
+		"outcome":       r.Outcome,
+		"lastStage":     r.LastStage,
+		"failedService": r.FailedService,
+		"errorMessage":  r.ErrorMessage,
+	}
+	for key, value := range optional {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	return fields
+}
+
+// MarshalJSON renders the record in the same format as the monitoring log event.
+func (r completionRecord) MarshalJSON() ([]byte, error) {
+	fields := r.fields()
+	fields["msg"] = r.Message
+	return json.Marshal(fields)
+}
+
+// CompletionSink receives the records of the closed transactions.
+type CompletionSink interface {
+	Send(record completionRecord) error
+	// Close flushes the pending records and releases the resources of the sink
+	Close() error
+}
+
+type completionSinksConfig struct {
+	sinkTypes    []string
+	ndjsonPath   string
+	webhookURL   string
+	kafkaBrokers []string
+	kafkaTopic   string
+}
+
+// newCompletionSink creates the configured sinks; if there are more of them, every record is sent to all of them.
+func newCompletionSink(config completionSinksConfig) (CompletionSink, error) {
+	var sinks multiSink
+	for _, sinkType := range config.sinkTypes {
+		var sink CompletionSink
+		var err error
+
+		switch strings.TrimSpace(sinkType) {
+		case "":
+			continue
+		case loggerSinkType:
+			sink = loggerSink{}
+		case ndjsonSinkType:
+			sink, err = newNDJSONFileSink(config.ndjsonPath)
+		case webhookSinkType:
+			sink, err = newWebhookSink(config.webhookURL, &http.Client{Timeout: 10 * time.Second})
+		case kafkaSinkType:
+			sink, err = newKafkaSink(config.kafkaBrokers, config.kafkaTopic, sarama.NewConfig())
+		default:
+			err = fmt.Errorf("unknown completion sink type: %s", sinkType)
+		}
+
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, errors.New("no completion sink has been configured")
+	case 1:
+		return sinks[0], nil
+	default:
+		return sinks, nil
+	}
+}
+
+// loggerSink logs the records as monitoring events; this is the format the rest of the monitoring relies on.
+type loggerSink struct{}
+
+func (loggerSink) Send(record completionRecord) error {
+	logger.Infof(record.fields(), record.Message)
+	return nil
+}
+
+func (loggerSink) Close() error {
+	return nil
+}
+
+// ndjsonFileSink appends every record to a file, as a JSON object per line.
+type ndjsonFileSink struct {
+	sync.Mutex
+	file *os.File
+}
+
+func newNDJSONFileSink(path string) (*ndjsonFileSink, error) {
+	if path == "" {
+		return nil, errors.New("no path has been configured for the NDJSON completion sink")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &ndjsonFileSink{file: file}, nil
+}
+
+func (s *ndjsonFileSink) Send(record completionRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+func (s *ndjsonFileSink) write(b []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	_, err := s.file.Write(append(b, '\n'))
+	return err
+}
+
+func (s *ndjsonFileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// dryRunReportSink appends the transactions a dry-run would have closed to a file, with the rule that has matched them.
+type dryRunReportSink struct {
+	*ndjsonFileSink
+}
+
+func newDryRunReportSink(path string) (*dryRunReportSink, error) {
+	file, err := newNDJSONFileSink(path)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunReportSink{file}, nil
+}
+
+func (s *dryRunReportSink) Send(record completionRecord) error {
+	b, err := json.Marshal(dryRunCandidate(record))
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// dryRunCandidate is the report entry of a transaction that would have been closed.
+type dryRunCandidate completionRecord
+
+func (c dryRunCandidate) MarshalJSON() ([]byte, error) {
+	fields := completionRecord(c).fields()
+	fields["msg"] = c.Message
+	fields["rule"] = c.Rule
+	fields["dry_run"] = true
+	return json.Marshal(fields)
+}
+
+// webhookSink posts every record as JSON to the given URL.
+type webhookSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func newWebhookSink(url string, httpClient *http.Client) (*webhookSink, error) {
+	if url == "" {
+		return nil, errors.New("no URL has been configured for the webhook completion sink")
+	}
+	return &webhookSink{url: url, httpClient: httpClient}, nil
+}
+
+func (s *webhookSink) Send(record completionRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer cleanUp(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with status %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+// kafkaSink produces every record as a JSON message to a Kafka topic; messages are keyed by content UUID,
+// so that the records of the same content stay in order.
+type kafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func newKafkaSink(brokers []string, topic string, config *sarama.Config) (*kafkaSink, error) {
+	if len(brokers) == 0 || topic == "" {
+		return nil, errors.New("no brokers or topic have been configured for the Kafka completion sink")
+	}
+
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSink{producer: producer, topic: topic}, nil
+}
+
+func (s *kafkaSink) Send(record completionRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: s.topic,
+		Key:   sarama.StringEncoder(record.UUID),
+		Value: sarama.ByteEncoder(b),
+	})
+	return err
+}
+
+func (s *kafkaSink) Close() error {
+	return s.producer.Close()
+}
+
+// collectingSink keeps the records it has forwarded to the next sink, so that the closures of a cycle can be reported;
+// the records that haven't reached any of the sinks are not kept, as their transactions are still open.
+type collectingSink struct {
+	sync.Mutex
+	next    CompletionSink
+	records []completionRecord
+}
+
+func (s *collectingSink) Send(record completionRecord) error {
+	var err error
+	if s.next != nil {
+		err = s.next.Send(record)
+	}
+
+	var partial partialSendError
+	if err == nil || errors.As(err, &partial) {
+		s.Lock()
+		s.records = append(s.records, record)
+		s.Unlock()
+	}
+	return err
+}
+
+// Close doesn't close the next sink, which is owned by the caller.
+func (s *collectingSink) Close() error {
+	return nil
+}
+
+func (s *collectingSink) collected() []completionRecord {
+	s.Lock()
+	defer s.Unlock()
+	return append([]completionRecord{}, s.records...)
+}
+
+// multiSink sends every record to all of its sinks.
+type multiSink []CompletionSink
+
+// partialSendError reports that the record has been sent to some of the sinks, but not to all of them.
+type partialSendError struct {
+	err error
+}
+
+func (e partialSendError) Error() string {
+	return e.err.Error()
+}
+
+func (e partialSendError) Unwrap() error {
+	return e.err
+}
+
+func (sinks multiSink) Send(record completionRecord) error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Send(record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 && len(errs) < len(sinks) {
+		return partialSendError{errors.Join(errs...)}
+	}
+	return errors.Join(errs...)
+}
+
+func (sinks multiSink) Close() error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
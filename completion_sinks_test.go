@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCompletionRecord = completionRecord{
+	TransactionID: "tid1",
+	UUID:          "uuid1",
+	ContentType:   annotationsContentType,
+	StartTime:     "2017-09-22T11:45:47.23038034Z",
+	EndTime:       "2017-09-22T11:45:53.23038034Z",
+	LogTime:       "2017-09-22T11:50:00.00000000Z",
+	Duration:      6 * time.Second,
+	IsValid:       "true",
+	Result:        completedResult,
+	Message:       "Transaction has finished",
+}
+
+func Test_completionRecord_fields(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{
+		"@time":                "2017-09-22T11:45:53.23038034Z",
+		"logTime":              "2017-09-22T11:50:00.00000000Z",
+		"event":                endEvent,
+		"transaction_id":       "tid1",
+		"uuid":                 "uuid1",
+		"startTime":            "2017-09-22T11:45:47.23038034Z",
+		"endTime":              "2017-09-22T11:45:53.23038034Z",
+		"transaction_duration": "6",
+		"monitoring_event":     "true",
+		"isValid":              "true",
+		"content_type":         annotationsContentType,
+	}, testCompletionRecord.fields())
+
+	// superseded transactions have no isValid field
+	superseded := testCompletionRecord
+	superseded.IsValid = ""
+	superseded.SupersededBy = "tid2"
+	_, found := superseded.fields()["isValid"]
+	assert.False(t, found)
+
+	failed := testCompletionRecord
+	failed.IsValid = failedOutcome
+	failed.Outcome = failedOutcome
+	failed.LastStage = completenessCriteriaEvent
+	failed.FailedService = "annotations-rw-neo4j"
+	failed.ErrorMessage = "Neo4j is unavailable"
+	fields := failed.fields()
+	assert.Equal(t, failedOutcome, fields["outcome"])
+	assert.Equal(t, completenessCriteriaEvent, fields["lastStage"])
+	assert.Equal(t, "annotations-rw-neo4j", fields["failedService"])
+	assert.Equal(t, "Neo4j is unavailable", fields["errorMessage"])
+}
+
+func Test_completionRecord_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(testCompletionRecord)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, "Transaction has finished", decoded["msg"])
+	assert.Equal(t, "tid1", decoded["transaction_id"])
+	assert.Equal(t, "6", decoded["transaction_duration"])
+	assert.Equal(t, endEvent, decoded["event"])
+}
+
+func Test_loggerSink(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	assert.NoError(t, loggerSink{}.Send(testCompletionRecord))
+
+	assert.Equal(t, "info", hook.LastEntry().Level.String())
+	assert.Equal(t, "Transaction has finished", hook.LastEntry().Message)
+	assert.Equal(t, "tid1", hook.LastEntry().Data["transaction_id"])
+	assert.Equal(t, "true", hook.LastEntry().Data["monitoring_event"])
+}
+
+func Test_ndjsonFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "completions.ndjson")
+	sink, err := newNDJSONFileSink(path)
+	assert.NoError(t, err)
+
+	second := testCompletionRecord
+	second.TransactionID = "tid2"
+	assert.NoError(t, sink.Send(testCompletionRecord))
+	assert.NoError(t, sink.Send(second))
+	assert.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	var tids []interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var decoded map[string]interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &decoded))
+		tids = append(tids, decoded["transaction_id"])
+	}
+	assert.Equal(t, []interface{}{"tid1", "tid2"}, tids)
+
+	_, err = newNDJSONFileSink("")
+	assert.Error(t, err)
+}
+
+func Test_dryRunReportSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "report.ndjson")
+	sink, err := newDryRunReportSink(path)
+	assert.NoError(t, err)
+
+	record := testCompletionRecord
+	record.Rule = "all stores"
+	assert.NoError(t, sink.Send(record))
+	assert.NoError(t, sink.Close())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, "tid1", decoded["transaction_id"])
+	assert.Equal(t, "all stores", decoded["rule"])
+	assert.Equal(t, true, decoded["dry_run"])
+	assert.Equal(t, "Transaction has finished", decoded["msg"])
+}
+
+func Test_webhookSink(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		if received["transaction_id"] == "rejected" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := newWebhookSink(server.URL, http.DefaultClient)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.Send(testCompletionRecord))
+	assert.Equal(t, "tid1", received["transaction_id"])
+	assert.Equal(t, "Transaction has finished", received["msg"])
+
+	rejected := testCompletionRecord
+	rejected.TransactionID = "rejected"
+	assert.EqualError(t, sink.Send(rejected), "webhook "+server.URL+" responded with status 400")
+
+	_, err = newWebhookSink("", http.DefaultClient)
+	assert.Error(t, err)
+}
+
+func Test_kafkaSink(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("completions", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError("completions", 0, sarama.ErrNoError),
+	})
+
+	config := sarama.NewConfig()
+	config.Producer.Retry.Max = 0
+	sink, err := newKafkaSink([]string{broker.Addr()}, "completions", config)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.Send(testCompletionRecord))
+	assert.NoError(t, sink.Close())
+
+	produced := 0
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced++
+			assert.NotNil(t, req)
+		}
+	}
+	assert.Equal(t, 1, produced)
+
+	_, err = newKafkaSink(nil, "completions", sarama.NewConfig())
+	assert.Error(t, err)
+}
+
+func Test_multiSink(t *testing.T) {
+	first := &recordingSink{}
+	second := &recordingSink{err: errors.New("unavailable")}
+	sinks := multiSink{first, second}
+
+	err := sinks.Send(testCompletionRecord)
+	assert.EqualError(t, err, "unavailable")
+	assert.IsType(t, partialSendError{}, err)
+	assert.Equal(t, []completionRecord{testCompletionRecord}, first.records)
+	assert.Equal(t, []completionRecord{testCompletionRecord}, second.records)
+
+	assert.NoError(t, sinks.Close())
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+}
+
+func Test_multiSink_AllFailed(t *testing.T) {
+	sinks := multiSink{&recordingSink{err: errors.New("unavailable")}, &recordingSink{err: errors.New("timed out")}}
+
+	err := sinks.Send(testCompletionRecord)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &partialSendError{}))
+}
+
+func Test_newCompletionSink(t *testing.T) {
+	sink, err := newCompletionSink(completionSinksConfig{sinkTypes: []string{loggerSinkType}})
+	assert.NoError(t, err)
+	assert.Equal(t, loggerSink{}, sink)
+
+	dir, err := ioutil.TempDir("", "sinks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sink, err = newCompletionSink(completionSinksConfig{
+		sinkTypes:  []string{loggerSinkType, ndjsonSinkType},
+		ndjsonPath: filepath.Join(dir, "completions.ndjson"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(sink.(multiSink)))
+	sink.Close()
+
+	_, err = newCompletionSink(completionSinksConfig{sinkTypes: []string{"email"}})
+	assert.EqualError(t, err, "unknown completion sink type: email")
+
+	_, err = newCompletionSink(completionSinksConfig{})
+	assert.EqualError(t, err, "no completion sink has been configured")
+
+	_, err = newCompletionSink(completionSinksConfig{sinkTypes: []string{webhookSinkType}})
+	assert.Error(t, err)
+}
+
+func Test_CloseCompletedTransactions_CustomSink(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	sink := &recordingSink{}
+	am := AnnotationsMonitoringService{
+		contentType: contentTypeRegistry[annotationsContentType],
+		sink:        sink,
+	}
+
+	am.CloseTimedOutTransactions(transactions{}, nil)
+	am.closeFailedTransaction(transactionEvent{TransactionID: "tid1", UUID: "uuid1"}, "2017-09-22T11:45:47.23038034Z",
+		publishEvent{Time: "2017-09-22T11:45:49.23038034Z", Event: "Map", Level: "error", ServiceName: "mapper", Msg: "failure"})
+
+	assert.Equal(t, 0, len(hook.Entries))
+	assert.Equal(t, 1, len(sink.records))
+	assert.Equal(t, "tid1", sink.records[0].TransactionID)
+	assert.Equal(t, annotationsContentType, sink.records[0].ContentType)
+	assert.Equal(t, failedResult, sink.records[0].Result)
+	assert.Equal(t, 2*time.Second, sink.records[0].Duration)
+	assert.NotEmpty(t, sink.records[0].LogTime)
+}
+
+func Test_closeTransaction_SendFailure(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	sink := &recordingSink{err: errors.New("unavailable")}
+	am := AnnotationsMonitoringService{
+		contentType: contentTypeRegistry[annotationsContentType],
+		closedTxs:   newClosedTransactionIndex(time.Hour),
+		sink:        sink,
+	}
+
+	// the completion hasn't been received: the transaction isn't remembered as closed, so it is closed again by the next cycle
+	assert.False(t, am.closeTransaction(testCompletionRecord))
+	assert.False(t, am.closedTxs.isDuplicate(testCompletionRecord.TransactionID))
+
+	sink.err = nil
+	assert.True(t, am.closeTransaction(testCompletionRecord))
+	assert.True(t, am.closedTxs.isDuplicate(testCompletionRecord.TransactionID))
+	assert.Equal(t, 2, len(sink.records))
+
+	// received by some of the sinks only: it isn't closed again
+	am = AnnotationsMonitoringService{
+		contentType: contentTypeRegistry[annotationsContentType],
+		closedTxs:   newClosedTransactionIndex(time.Hour),
+		sink:        multiSink{&recordingSink{}, &recordingSink{err: errors.New("unavailable")}},
+	}
+	assert.True(t, am.closeTransaction(testCompletionRecord))
+	assert.True(t, am.closedTxs.isDuplicate(testCompletionRecord.TransactionID))
+}
+
+func Test_collectingSink_OnlyKeepsSentRecords(t *testing.T) {
+	failing := &recordingSink{err: errors.New("unavailable")}
+	sink := &collectingSink{next: failing}
+
+	assert.Error(t, sink.Send(testCompletionRecord))
+	assert.Empty(t, sink.collected())
+
+	// received by some of the sinks
+	sink.next = multiSink{&recordingSink{}, failing}
+	assert.Error(t, sink.Send(testCompletionRecord))
+	assert.Len(t, sink.collected(), 1)
+
+	// dry-run, without any sink
+	sink.next = nil
+	assert.NoError(t, sink.Send(testCompletionRecord))
+	assert.Len(t, sink.collected(), 2)
+}
+
+// recordingSink keeps the records it receives in memory
+type recordingSink struct {
+	records []completionRecord
+	err     error
+	closed  bool
+}
+
+func (s *recordingSink) Send(record completionRecord) error {
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
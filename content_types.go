@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	annotationsContentType = "Annotations"
+	suggestionsContentType = "Suggestions"
+	conceptsContentType    = "Concepts"
+
+	mapperEvent = "Map"
+)
+
+// contentTypeConfig describes how the transactions of a particular content type should be monitored.
+type contentTypeConfig struct {
+	// name of the content type, as it appears in the content_type field of the publish events
+	name string
+	// event marking the beginning of a transaction
+	startEvent string
+	// rule deciding whether the content has been successfully written
+	completeness completenessRule
+	// whether a transaction can only be closed after it has been marked as valid or invalid (isValid flag)
+	validationRequired bool
+	// events logged by the services the content goes through; an error logged by any of them (or by a stage
+	// required by the completeness rule) fails the transaction
+	pipelineStages []string
+	// how long a transaction can stay open before it is closed as timed out; zero disables timeouts
+	timeout time.Duration
+}
+
+// readerContentType returns the content type in the format expected by the event reader.
+func (c contentTypeConfig) readerContentType() string {
+	return strings.ToLower(c.name)
+}
+
+// isPipelineStage checks whether the event is logged by one of the stages the content goes through.
+func (c contentTypeConfig) isPipelineStage(event string) bool {
+	for _, stage := range c.pipelineStages {
+		if stage == event {
+			return true
+		}
+	}
+	for _, clause := range [][]eventMatcher{c.completeness.AllOf, c.completeness.AnyOf, c.completeness.Sequence} {
+		for _, m := range clause {
+			if m.Event == event {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var contentTypeRegistry = map[string]contentTypeConfig{
+	annotationsContentType: {
+		name:               annotationsContentType,
+		startEvent:         startEvent,
+		completeness:       defaultCompletenessRule,
+		validationRequired: true,
+		pipelineStages:     []string{mapperEvent, completenessCriteriaEvent},
+	},
+	suggestionsContentType: {
+		name:               suggestionsContentType,
+		startEvent:         startEvent,
+		completeness:       defaultCompletenessRule,
+		validationRequired: true,
+		pipelineStages:     []string{mapperEvent, completenessCriteriaEvent},
+	},
+	conceptsContentType: {
+		name:               conceptsContentType,
+		startEvent:         startEvent,
+		completeness:       defaultCompletenessRule,
+		validationRequired: false,
+		pipelineStages:     []string{completenessCriteriaEvent},
+	},
+}
+
+// lookupContentTypes returns the registry entries for the given content type names (case insensitive).
+func lookupContentTypes(names []string) ([]contentTypeConfig, error) {
+	var result []contentTypeConfig
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for key, ct := range contentTypeRegistry {
+			if strings.EqualFold(key, name) {
+				result = append(result, ct)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown content type: %s", name)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no content type has been configured for monitoring")
+	}
+
+	return result, nil
+}
+
+// applyTransactionTimeouts parses the timeouts given in the ContentType=duration format (e.g. Annotations=30m)
+// and sets them on the matching content types.
+func applyTransactionTimeouts(specs []string, contentTypes []contentTypeConfig) ([]contentTypeConfig, error) {
+	result := make([]contentTypeConfig, len(contentTypes))
+	copy(result, contentTypes)
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid transaction timeout %q, expected ContentType=duration", spec)
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction timeout %q: %v", spec, err)
+		}
+
+		applied := false
+		for i := range result {
+			if strings.EqualFold(result[i].name, strings.TrimSpace(parts[0])) {
+				result[i].timeout = timeout
+				applied = true
+			}
+		}
+		if !applied {
+			return nil, fmt.Errorf("transaction timeout defined for a content type that is not monitored: %s", parts[0])
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lookupContentTypes(t *testing.T) {
+	var tests = []struct {
+		names    []string
+		expected []string
+		errMsg   string
+	}{
+		{[]string{"Annotations"}, []string{annotationsContentType}, ""},
+		{[]string{"annotations", " Concepts "}, []string{annotationsContentType, conceptsContentType}, ""},
+		{[]string{"Annotations", "Videos"}, nil, "unknown content type: Videos"},
+		{[]string{""}, nil, "no content type has been configured for monitoring"},
+		{nil, nil, "no content type has been configured for monitoring"},
+	}
+
+	for _, test := range tests {
+		cts, err := lookupContentTypes(test.names)
+		if test.errMsg != "" {
+			assert.EqualError(t, err, test.errMsg)
+			continue
+		}
+
+		assert.NoError(t, err)
+		var names []string
+		for _, ct := range cts {
+			names = append(names, ct.name)
+		}
+		assert.Equal(t, test.expected, names)
+	}
+}
+
+func Test_readerContentType(t *testing.T) {
+	assert.Equal(t, "annotations", contentTypeRegistry[annotationsContentType].readerContentType())
+	assert.Equal(t, "suggestions", contentTypeRegistry[suggestionsContentType].readerContentType())
+}
+
+func Test_applyTransactionTimeouts(t *testing.T) {
+	cts := []contentTypeConfig{contentTypeRegistry[annotationsContentType], contentTypeRegistry[conceptsContentType]}
+
+	result, err := applyTransactionTimeouts([]string{"annotations=30m", " Concepts = 2h "}, cts)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, result[0].timeout)
+	assert.Equal(t, 2*time.Hour, result[1].timeout)
+	// the registry is not modified
+	assert.Equal(t, time.Duration(0), contentTypeRegistry[annotationsContentType].timeout)
+
+	result, err = applyTransactionTimeouts(nil, cts)
+	assert.NoError(t, err)
+	assert.Equal(t, cts, result)
+
+	_, err = applyTransactionTimeouts([]string{"Annotations"}, cts)
+	assert.EqualError(t, err, `invalid transaction timeout "Annotations", expected ContentType=duration`)
+
+	_, err = applyTransactionTimeouts([]string{"Annotations=soon"}, cts)
+	assert.Error(t, err)
+
+	_, err = applyTransactionTimeouts([]string{"Suggestions=1h"}, cts)
+	assert.EqualError(t, err, "transaction timeout defined for a content type that is not monitored: Suggestions")
+}
+
+func Test_isPipelineStage(t *testing.T) {
+	ct := contentTypeRegistry[annotationsContentType]
+	assert.True(t, ct.isPipelineStage(mapperEvent))
+	assert.True(t, ct.isPipelineStage(completenessCriteriaEvent))
+	assert.False(t, ct.isPipelineStage("SavePostgres"))
+	assert.False(t, ct.isPipelineStage(startEvent))
+
+	// the events required by the completeness rule are pipeline stages as well
+	ct.completeness = completenessRule{Sequence: []eventMatcher{{Event: "SaveNeo4j"}, {Event: "SavePostgres"}}}
+	assert.True(t, ct.isPipelineStage("SavePostgres"))
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+const (
+	splunkEventReaderType        = "splunk"
+	elasticsearchEventReaderType = "elasticsearch"
+
+	// fields of the indexed publish events, as they are logged
+	timeField          = "@time"
+	transactionIDField = "transaction_id"
+	uuidField          = "uuid"
+	contentTypeField   = "content_type"
+	eventField         = "event"
+
+	// the events of the transactions are looked up by batches of transaction IDs, well within the terms limit of Elasticsearch
+	transactionIDsBatchSize = 1000
+)
+
+// ElasticsearchEventReader queries an Elasticsearch (or OpenSearch) index of publish events, instead of the Splunk event reader,
+// and groups the events into transactions.
+type ElasticsearchEventReader struct {
+	address string
+	// name or pattern of the indices holding the publish events
+	index    string
+	pageSize int
+	eventReaderCalls
+}
+
+type elasticsearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source publishEvent  `json:"_source"`
+			Sort   []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (r ElasticsearchEventReader) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
+	return r.GetTransactionsForUUIDs(ctx, contentType, nil, lookbackPeriod)
+}
+
+func (r ElasticsearchEventReader) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	events, err := r.searchAll(ctx, uuidsFilters(contentType, uuids, lookbackPeriod))
+	if err != nil {
+		return nil, err
+	}
+	return openTransactionsOf(events), nil
+}
+
+func (r ElasticsearchEventReader) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	events, err := r.searchAll(ctx, uuidsFilters(contentType, uuids, lookbackPeriod))
+	if err != nil {
+		return nil, err
+	}
+	return transactionsOf(events), nil
+}
+
+// uuidsFilters matches the events of the contents within the lookback period; all the contents if there are no UUIDs.
+func uuidsFilters(contentType string, uuids []string, lookbackPeriod string) []interface{} {
+	filters := []interface{}{
+		contentTypeFilter(contentType),
+		timeRangeFilter("now-"+lookbackPeriod, ""),
+	}
+	if len(uuids) != 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{uuidField: uuids}})
+	}
+	return filters
+}
+
+// GetTransactionsBetween selects the transactions by the time of their PublishStart event, then fetches all of their events,
+// including the ones logged after the end of the window.
+func (r ElasticsearchEventReader) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	starts, err := r.searchAll(ctx, []interface{}{
+		contentTypeFilter(contentType),
+		map[string]interface{}{"term": map[string]interface{}{eventField: startEvent}},
+		timeRangeFilter(earliest.UTC().Format(defaultTimestampFormat), latest.UTC().Format(defaultTimestampFormat)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var tids []string
+	seen := map[string]bool{}
+	for _, event := range starts {
+		if !seen[event.TransactionID] {
+			seen[event.TransactionID] = true
+			tids = append(tids, event.TransactionID)
+		}
+	}
+	if len(tids) == 0 {
+		return transactions{}, nil
+	}
+
+	var events []publishEvent
+	for _, tids := range batch(tids, transactionIDsBatchSize) {
+		batchEvents, err := r.searchAll(ctx, []interface{}{
+			contentTypeFilter(contentType),
+			map[string]interface{}{"terms": map[string]interface{}{transactionIDField: tids}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, batchEvents...)
+	}
+	return openTransactionsOf(events), nil
+}
+
+func (r ElasticsearchEventReader) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := map[string]interface{}{
+		"size":  1,
+		"sort":  []interface{}{map[string]interface{}{timeField: "desc"}},
+		"query": boolFilter(contentTypeFilter(contentType), timeRangeFilter("now-"+lookbackPeriod, "")),
+	}
+
+	result, err := r.search(ctx, query)
+	if err != nil {
+		return publishEvent{}, err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return publishEvent{}, errors.New("Failed to retrieve latest log event")
+	}
+	return result.Hits.Hits[0].Source, nil
+}
+
+func (r ElasticsearchEventReader) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	filters := []interface{}{
+		contentTypeFilter(contentType),
+		timeRangeFilter("now-"+lookbackPeriod, ""),
+		map[string]interface{}{"term": map[string]interface{}{transactionIDField: transactionID}},
+	}
+
+	events, err := r.searchAll(ctx, filters)
+	if err != nil {
+		return transactionEvent{}, err
+	}
+
+	txs := transactionsOf(events)
+	if len(txs) == 0 {
+		return transactionEvent{}, errTransactionNotFound
+	}
+	return txs[0], nil
+}
+
+// searchAll retrieves all the events matched by the filters, page by page, in chronological order.
+func (r ElasticsearchEventReader) searchAll(ctx context.Context, filters []interface{}) ([]publishEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	pageSize := r.pageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+
+	var events []publishEvent
+	var searchAfter []interface{}
+	for {
+		query := map[string]interface{}{
+			"size": pageSize,
+			// the sort has to be unique enough for the pages not to skip events sharing the same time
+			"sort": []interface{}{
+				map[string]interface{}{timeField: "asc"},
+				map[string]interface{}{transactionIDField: "asc"},
+				map[string]interface{}{eventField: "asc"},
+			},
+			"query": boolFilter(filters...),
+		}
+		if searchAfter != nil {
+			query["search_after"] = searchAfter
+		}
+
+		result, err := r.search(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hit := range result.Hits.Hits {
+			events = append(events, hit.Source)
+		}
+		if len(result.Hits.Hits) < pageSize {
+			return events, nil
+		}
+		searchAfter = result.Hits.Hits[len(result.Hits.Hits)-1].Sort
+	}
+}
+
+func (r ElasticsearchEventReader) search(ctx context.Context, query map[string]interface{}) (elasticsearchResponse, error) {
+	body, err := json.Marshal(query)
+	if err != nil {
+		return elasticsearchResponse{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.address+"/"+url.PathEscape(r.index)+"/_search", bytes.NewReader(body))
+	if err != nil {
+		return elasticsearchResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.retryPolicy.do(r.httpClient, req)
+	if err != nil {
+		logger.Errorf(map[string]interface{}{
+			"url": req.URL.String(),
+		}, err, "Failed to search publish events")
+		return elasticsearchResponse{}, err
+	}
+	defer cleanUp(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorf(map[string]interface{}{
+			"url":         req.URL.String(),
+			"status code": resp.StatusCode,
+		}, nil, "Failed to search publish events")
+		return elasticsearchResponse{}, fmt.Errorf("searching publish events has failed with status %d", resp.StatusCode)
+	}
+
+	var result elasticsearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		logger.Errorf(map[string]interface{}{
+			"url": req.URL.String(),
+		}, err, "Error unmarshalling publish events")
+		return elasticsearchResponse{}, err
+	}
+	return result, nil
+}
+
+func boolFilter(filters ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": filters}}
+}
+
+// contentTypeFilter matches the content type regardless of its case: the event reader uses lower case content types,
+// while they are logged capitalised.
+func contentTypeFilter(contentType string) map[string]interface{} {
+	return map[string]interface{}{"term": map[string]interface{}{
+		contentTypeField: map[string]interface{}{"value": contentType, "case_insensitive": true},
+	}}
+}
+
+// timeRangeFilter matches the events logged from gte (included) to lt (excluded); an empty bound is left open.
+func timeRangeFilter(gte, lt string) map[string]interface{} {
+	bounds := map[string]interface{}{"gte": gte}
+	if lt != "" {
+		bounds["lt"] = lt
+	}
+	return map[string]interface{}{"range": map[string]interface{}{timeField: bounds}}
+}
+
+// transactionsOf groups the events by transaction, ordered by start time.
+func transactionsOf(events []publishEvent) transactions {
+	index := map[string]int{}
+	var txs transactions
+	for _, event := range events {
+		i, found := index[event.TransactionID]
+		if !found {
+			i = len(txs)
+			index[event.TransactionID] = i
+			txs = append(txs, transactionEvent{TransactionID: event.TransactionID, UUID: event.UUID, StartTime: event.Time})
+		}
+
+		tx := &txs[i]
+		tx.Events = append(tx.Events, event)
+		tx.EventCount++
+		if event.Event == startEvent || event.parsedTime().Before(tx.startedAt()) {
+			tx.StartTime = event.Time
+		}
+	}
+
+	sort.Sort(txs)
+	return txs
+}
+
+// openTransactionsOf groups the events by transaction, leaving out the ones a PublishEnd event has been logged for.
+func openTransactionsOf(events []publishEvent) transactions {
+	txs := transactions{}
+	for _, tx := range transactionsOf(events) {
+		if !isClosed(tx) {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
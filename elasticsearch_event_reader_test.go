@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// elasticsearchStandIn serves the given events as search hits, one page after the other, and records the search requests.
+type elasticsearchStandIn struct {
+	t        *testing.T
+	pages    [][]publishEvent
+	requests []map[string]interface{}
+}
+
+func (s *elasticsearchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(s.t, "POST", r.Method)
+	assert.Equal(s.t, "/publish-events/_search", r.URL.Path)
+
+	var query map[string]interface{}
+	assert.NoError(s.t, json.NewDecoder(r.Body).Decode(&query))
+	page := len(s.requests)
+	s.requests = append(s.requests, query)
+
+	var hits []string
+	if page < len(s.pages) {
+		for i, event := range s.pages[page] {
+			b, err := json.Marshal(event)
+			assert.NoError(s.t, err)
+			hits = append(hits, fmt.Sprintf(`{"_source": %s, "sort": [%d, %d]}`, b, page, i))
+		}
+	}
+	fmt.Fprintf(w, `{"hits": {"hits": [%s]}}`, strings.Join(hits, ","))
+}
+
+func (s *elasticsearchStandIn) filters(request int) []interface{} {
+	return s.requests[request]["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]interface{})
+}
+
+func Test_ElasticsearchEventReader_GetTransactionsForUUIDs(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	standIn := &elasticsearchStandIn{t: t, pages: [][]publishEvent{
+		{
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:45:00.00Z"},
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_2", UUID: "uuid1", Time: "2017-09-22T11:46:00.00Z"},
+		},
+		{
+			{ContentType: annotationsContentType, Event: "Map", TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:47:00.00Z"},
+			{ContentType: annotationsContentType, Event: endEvent, TransactionID: "tid_2", UUID: "uuid1", Time: "2017-09-22T11:48:00.00Z"},
+		},
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events", pageSize: 2}
+	txs, err := reader.GetTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{"uuid1"}, "60m")
+
+	assert.NoError(t, err)
+	// tid_2 has been closed already
+	assert.Equal(t, 1, len(txs))
+	assert.Equal(t, "tid_1", txs[0].TransactionID)
+	assert.Equal(t, "uuid1", txs[0].UUID)
+	assert.Equal(t, "2017-09-22T11:45:00.00Z", txs[0].StartTime)
+	assert.Equal(t, 2, txs[0].EventCount)
+	assert.Equal(t, "Map", txs[0].Events[1].Event)
+
+	// the last page is the first one not to be full
+	assert.Equal(t, 3, len(standIn.requests))
+	assert.Nil(t, standIn.requests[0]["search_after"])
+	assert.Equal(t, []interface{}{float64(0), float64(1)}, standIn.requests[1]["search_after"])
+	assert.Equal(t, []interface{}{float64(1), float64(1)}, standIn.requests[2]["search_after"])
+
+	filters := standIn.filters(0)
+	assert.Equal(t, contentTypeField, firstKey(filters[0], "term"))
+	assert.Equal(t, map[string]interface{}{"gte": "now-60m"}, filters[1].(map[string]interface{})["range"].(map[string]interface{})[timeField])
+	assert.Equal(t, []interface{}{"uuid1"}, filters[2].(map[string]interface{})["terms"].(map[string]interface{})[uuidField])
+}
+
+func Test_ElasticsearchEventReader_GetAllTransactionsForUUIDs(t *testing.T) {
+	standIn := &elasticsearchStandIn{t: t, pages: [][]publishEvent{
+		{
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:45:00.00Z"},
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_2", UUID: "uuid1", Time: "2017-09-22T11:46:00.00Z"},
+			{ContentType: annotationsContentType, Event: endEvent, TransactionID: "tid_2", UUID: "uuid1", Time: "2017-09-22T11:48:00.00Z"},
+		},
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events", pageSize: 10}
+	txs, err := reader.GetAllTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{"uuid1"}, "60m")
+
+	assert.NoError(t, err)
+	// the closed tid_2 is returned too
+	assert.Equal(t, []string{"tid_1", "tid_2"}, transactionIDs(txs))
+	assert.Equal(t, 2, txs[1].EventCount)
+
+	filters := standIn.filters(0)
+	assert.Equal(t, []interface{}{"uuid1"}, filters[2].(map[string]interface{})["terms"].(map[string]interface{})[uuidField])
+}
+
+func Test_ElasticsearchEventReader_GetTransactionsBetween(t *testing.T) {
+	standIn := &elasticsearchStandIn{t: t, pages: [][]publishEvent{
+		// the transactions that have started within the window
+		{
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:59:00.00Z"},
+		},
+		// all of their events
+		{
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:59:00.00Z"},
+			{ContentType: annotationsContentType, Event: "Map", TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T12:01:00.00Z"},
+		},
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	earliest := time.Date(2017, 9, 22, 11, 0, 0, 0, time.UTC)
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events"}
+	txs, err := reader.GetTransactionsBetween(context.Background(), strings.ToLower(annotationsContentType), earliest, earliest.Add(time.Hour))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_1"}, transactionIDs(txs))
+	// the events logged after the end of the window are kept
+	assert.Equal(t, 2, txs[0].EventCount)
+	assert.Equal(t, "2017-09-22T11:59:00.00Z", txs[0].StartTime)
+
+	assert.Equal(t, 2, len(standIn.requests))
+	filters := standIn.filters(0)
+	assert.Equal(t, startEvent, filters[1].(map[string]interface{})["term"].(map[string]interface{})[eventField])
+	assert.Equal(t, map[string]interface{}{"gte": "2017-09-22T11:00:00Z", "lt": "2017-09-22T12:00:00Z"},
+		filters[2].(map[string]interface{})["range"].(map[string]interface{})[timeField])
+
+	filters = standIn.filters(1)
+	assert.Equal(t, 2, len(filters))
+	assert.Equal(t, []interface{}{"tid_1"}, filters[1].(map[string]interface{})["terms"].(map[string]interface{})[transactionIDField])
+}
+
+func Test_ElasticsearchEventReader_GetTransactionsBetween_NoTransactions(t *testing.T) {
+	standIn := &elasticsearchStandIn{t: t}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	earliest := time.Date(2017, 9, 22, 11, 0, 0, 0, time.UTC)
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events"}
+	txs, err := reader.GetTransactionsBetween(context.Background(), strings.ToLower(annotationsContentType), earliest, earliest.Add(time.Hour))
+
+	assert.NoError(t, err)
+	assert.Equal(t, transactions{}, txs)
+	assert.Equal(t, 1, len(standIn.requests))
+}
+
+func Test_ElasticsearchEventReader_GetLatestEvent(t *testing.T) {
+	standIn := &elasticsearchStandIn{t: t, pages: [][]publishEvent{
+		{{ContentType: annotationsContentType, Event: endEvent, TransactionID: "tid_1", Time: "2017-09-22T11:48:00.00Z"}},
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events"}
+	event, err := reader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2017-09-22T11:48:00.00Z", event.Time)
+	assert.Equal(t, float64(1), standIn.requests[0]["size"])
+	assert.Equal(t, []interface{}{map[string]interface{}{timeField: "desc"}}, standIn.requests[0]["sort"])
+
+	// no event within the lookback period
+	_, err = reader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
+	assert.Error(t, err)
+}
+
+func Test_ElasticsearchEventReader_GetTransaction(t *testing.T) {
+	standIn := &elasticsearchStandIn{t: t, pages: [][]publishEvent{
+		{
+			{ContentType: annotationsContentType, Event: startEvent, TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:45:00.00Z"},
+			{ContentType: annotationsContentType, Event: endEvent, TransactionID: "tid_1", UUID: "uuid1", Time: "2017-09-22T11:48:00.00Z"},
+		},
+	}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events"}
+	tx, err := reader.GetTransaction(context.Background(), strings.ToLower(annotationsContentType), "tid_1", "60m")
+
+	// closed transactions are returned too
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tx.Events))
+	assert.Equal(t, map[string]interface{}{transactionIDField: "tid_1"}, standIn.filters(0)[2].(map[string]interface{})["term"])
+
+	_, err = reader.GetTransaction(context.Background(), strings.ToLower(annotationsContentType), "tid_2", "60m")
+	assert.Equal(t, errTransactionNotFound, err)
+}
+
+func Test_ElasticsearchEventReader_ServerError(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	reader := ElasticsearchEventReader{address: server.URL, index: "publish-events"}
+	_, err := reader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.Error(t, err)
+	assert.Equal(t, "Failed to search publish events", hook.LastEntry().Message)
+	assert.Equal(t, http.StatusInternalServerError, hook.LastEntry().Data["status code"])
+}
+
+func firstKey(clause interface{}, kind string) string {
+	for key := range clause.(map[string]interface{})[kind].(map[string]interface{}) {
+		return key
+	}
+	return ""
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
 	"fmt"
 	"github.com/Financial-Times/go-logger"
@@ -19,24 +23,55 @@ const (
 )
 
 type EventReader interface {
-	GetTransactions(contentType string, lookbackPeriod string) (transactions, error)
-	GetTransactionsForUUIDs(contentType string, uuids []string, lookbackPeriod string) (transactions, error)
-	GetLatestEvent(contentType string, lookbackPeriod string) (publishEvent, error)
+	GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error)
+	// GetTransactionsForUUIDs may return the transactions it could retrieve along with an error, if it has only partially failed
+	GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error)
+	// GetAllTransactionsForUUIDs returns all the transactions of the contents, closed or not; the event readers that only know
+	// the open transactions return them along with errClosedTransactionsUnavailable
+	GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error)
+	// GetTransactionsBetween returns the open transactions that have started within the given absolute time window
+	GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error)
+	GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error)
+	// GetTransaction returns all the events of a transaction (closed or not, if the event reader knows the closed ones);
+	// errTransactionNotFound if there are none
+	GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error)
+}
+
+var (
+	errTransactionNotFound           = errors.New("transaction not found")
+	errClosedTransactionsUnavailable = errors.New("the event reader only returns the open transactions")
+)
+
+// TransactionStreamer is implemented by the event readers that can hand the open transactions over one at a time, as they
+// are decoded, so that a large lookback window doesn't have to be held in memory all at once.
+type TransactionStreamer interface {
+	StreamTransactions(ctx context.Context, contentType string, lookbackPeriod string, handle func(tx transactionEvent)) error
+	// StreamTransactionsForUUIDs hands over the transactions GetTransactionsForUUIDs returns, one at a time even if the
+	// UUIDs are retrieved in concurrent batches; the ones of the batches that have succeeded are handed over even if some have failed
+	StreamTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string, handle func(tx transactionEvent)) error
 }
 
 type SplunkEventReader struct {
 	eventReaderAddress string
+	eventReaderCalls
+	// the UUIDs are passed in the URL, so long lists are split in batches of that size (no limit if zero),
+	// which are retrieved concurrently by up to uuidBatchConcurrency requests
+	uuidBatchSize        int
+	uuidBatchConcurrency int
 }
 
-func (ser SplunkEventReader) GetLatestEvent(contentType string, lookbackPeriod string) (publishEvent, error) {
-	req, err := http.NewRequest("GET", ser.eventReaderAddress+"/"+contentType+"/events", nil)
+func (ser SplunkEventReader) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
+	ctx, cancel := withTimeout(ctx, ser.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ser.eventReaderAddress+"/"+contentType+"/events", nil)
 
 	q := req.URL.Query()
 	q.Add(earliestTimePathVar, fmt.Sprintf("-%s", lookbackPeriod))
 	q.Add(lastEventPathVar, strconv.FormatBool(true))
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := ser.retryPolicy.do(ser.httpClient, req)
 	if err != nil {
 		logger.Errorf(map[string]interface{}{
 			"url": req.URL.String(),
@@ -72,28 +107,158 @@ func (ser SplunkEventReader) GetLatestEvent(contentType string, lookbackPeriod s
 	return event, nil
 }
 
-func (ser SplunkEventReader) GetTransactions(contentType string, lookbackPeriod string) (transactions, error) {
-	return ser.GetTransactionsForUUIDs(contentType, nil, lookbackPeriod)
+func (ser SplunkEventReader) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
+	return ser.GetTransactionsForUUIDs(ctx, contentType, nil, lookbackPeriod)
 }
 
-func (ser SplunkEventReader) GetTransactionsForUUIDs(contentType string, uuids []string, earliestTime string) (transactions, error) {
+func (ser SplunkEventReader) StreamTransactions(ctx context.Context, contentType string, lookbackPeriod string, handle func(tx transactionEvent)) error {
+	q := url.Values{}
+	q.Add(earliestTimePathVar, fmt.Sprintf("-%s", lookbackPeriod))
+	return ser.streamTransactions(ctx, contentType, q, handle)
+}
 
-	req, err := http.NewRequest("GET", ser.eventReaderAddress+"/"+contentType+"/transactions", nil)
-	q := req.URL.Query()
+// GetTransactionsForUUIDs returns the transactions of all the batches that have succeeded, even if some of them have failed.
+func (ser SplunkEventReader) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, earliestTime string) (transactions, error) {
+	batches := batch(uuids, ser.uuidBatchSize)
+	if len(batches) <= 1 {
+		return ser.getTransactionsForUUIDs(ctx, contentType, uuids, earliestTime)
+	}
+
+	results := make([]transactions, len(batches))
+	err := ser.forEachUUIDBatch(uuids, func(i int, uuids []string) error {
+		var err error
+		results[i], err = ser.getTransactionsForUUIDs(ctx, contentType, uuids, earliestTime)
+		return err
+	})
+	return mergeTransactions(results...), err
+}
+
+// GetAllTransactionsForUUIDs can only return the open transactions: the event reader has no query for the closed ones.
+func (ser SplunkEventReader) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, earliestTime string) (transactions, error) {
+	txs, err := ser.GetTransactionsForUUIDs(ctx, contentType, uuids, earliestTime)
+	if err != nil {
+		return txs, err
+	}
+	return txs, errClosedTransactionsUnavailable
+}
+
+// StreamTransactionsForUUIDs batches the UUIDs as GetTransactionsForUUIDs does, and hands the transactions of the batches over in turn;
+// like mergeTransactions, it only hands a transaction returned by several batches over once.
+func (ser SplunkEventReader) StreamTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, earliestTime string, handle func(tx transactionEvent)) error {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	return ser.forEachUUIDBatch(uuids, func(_ int, uuids []string) error {
+		return ser.streamTransactions(ctx, contentType, transactionsForUUIDsQuery(uuids, earliestTime), func(tx transactionEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[tx.TransactionID] {
+				return
+			}
+			seen[tx.TransactionID] = true
+			handle(tx)
+		})
+	})
+}
+
+// forEachUUIDBatch calls retrieve for every batch of UUIDs, concurrently if there are several, and joins their errors.
+func (ser SplunkEventReader) forEachUUIDBatch(uuids []string, retrieve func(i int, uuids []string) error) error {
+	batches := batch(uuids, ser.uuidBatchSize)
+	if len(batches) <= 1 {
+		return retrieve(0, uuids)
+	}
+
+	concurrency := ser.uuidBatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+
+	errs := make([]error, len(batches))
+	var wg sync.WaitGroup
+	for i, uuids := range batches {
+		wg.Add(1)
+		go func(i int, uuids []string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs[i] = retrieve(i, uuids)
+		}(i, uuids)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (ser SplunkEventReader) getTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, earliestTime string) (transactions, error) {
+	return ser.getTransactions(ctx, contentType, transactionsForUUIDsQuery(uuids, earliestTime))
+}
+
+func transactionsForUUIDsQuery(uuids []string, earliestTime string) url.Values {
+	q := url.Values{}
 	if uuids != nil && len(uuids) != 0 {
 		for _, uuid := range uuids {
 			q.Add(uuidPathVar, uuid)
 		}
 	}
 	q.Add(earliestTimePathVar, fmt.Sprintf("-%s", earliestTime))
+	return q
+}
+
+// GetTransactionsBetween selects the transactions by the time of their PublishStart event: the event reader filters the events,
+// not the transactions, by time, so the open transactions are requested from the start of the window up to now, and the ones
+// that have started within the window are kept with all of their events, including the ones logged after its end.
+func (ser SplunkEventReader) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	txs := transactions{}
+	q := transactionsForUUIDsQuery(nil, fmt.Sprintf("%dm", lookbackPeriodSince(earliest)))
+	err := ser.streamTransactions(ctx, contentType, q, func(tx transactionEvent) {
+		if start, found := publishStartTime(tx); found && !start.Before(earliest) && start.Before(latest) {
+			txs = append(txs, tx)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// publishStartTime returns the time of the PublishStart event of the transaction, if it has been retrieved.
+func publishStartTime(tx transactionEvent) (time.Time, bool) {
+	for _, event := range tx.Events {
+		if event.Event == startEvent {
+			return event.parsedTime(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (ser SplunkEventReader) getTransactions(ctx context.Context, contentType string, q url.Values) (transactions, error) {
+	txs := transactions{}
+	err := ser.streamTransactions(ctx, contentType, q, func(tx transactionEvent) {
+		txs = append(txs, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// streamTransactions decodes the array of transactions incrementally, handing every transaction over as soon as it is decoded.
+func (ser SplunkEventReader) streamTransactions(ctx context.Context, contentType string, q url.Values, handle func(tx transactionEvent)) error {
+	ctx, cancel := withTimeout(ctx, ser.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ser.eventReaderAddress+"/"+contentType+"/transactions", nil)
+	if err != nil {
+		return err
+	}
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := ser.retryPolicy.do(ser.httpClient, req)
 	if err != nil {
 		logger.Errorf(map[string]interface{}{
 			"url": req.URL.String(),
 		}, err, "Failed to retrieve transactions")
-		return nil, err
+		return err
 	}
 	defer cleanUp(resp)
 
@@ -102,26 +267,89 @@ func (ser SplunkEventReader) GetTransactionsForUUIDs(contentType string, uuids [
 			"url":         req.URL.String(),
 			"status code": resp.StatusCode,
 		}, nil, "Failed to retrieve transactions")
-		return nil, errors.New("Failed to retrieve transactions")
+		return errors.New("Failed to retrieve transactions")
 	}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if err := decodeTransactions(json.NewDecoder(resp.Body), handle); err != nil {
 		logger.Errorf(map[string]interface{}{
 			"url": req.URL.String(),
-		}, err, "Error parsing transaction body")
-		return nil, err
+		}, err, "Error unmarshalling transaction log messages")
+		return err
 	}
+	return nil
+}
 
-	var txs transactions
-	if err := json.Unmarshal(b, &txs); err != nil {
-		logger.Errorf(map[string]interface{}{
-			"url": req.URL.String(),
-		}, err, "Error unmarshalling transaction log messages")
-		return nil, err
+// decodeTransactions decodes a JSON array of transactions one element at a time; null is decoded as no transactions.
+func decodeTransactions(decoder *json.Decoder, handle func(tx transactionEvent)) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected an array of transactions, got %v", token)
 	}
 
-	return txs, nil
+	for decoder.More() {
+		var tx transactionEvent
+		if err := decoder.Decode(&tx); err != nil {
+			return err
+		}
+		handle(tx)
+	}
+
+	// closing bracket
+	_, err = decoder.Token()
+	return err
+}
+
+// GetTransaction picks the transaction out of the transactions of the lookback period: the event reader only returns the open
+// ones, so a transaction that has already been closed is not found.
+func (ser SplunkEventReader) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	var found transactionEvent
+	err := ser.StreamTransactions(ctx, contentType, lookbackPeriod, func(tx transactionEvent) {
+		if tx.TransactionID == transactionID && found.TransactionID == "" {
+			found = tx
+		}
+	})
+	if err != nil {
+		return transactionEvent{}, err
+	}
+	if found.TransactionID == "" {
+		return transactionEvent{}, errTransactionNotFound
+	}
+	return found, nil
+}
+
+// batch splits the values in batches of the given size; they are kept in a single batch if the size is zero.
+func batch(values []string, size int) [][]string {
+	if size <= 0 || len(values) <= size {
+		return [][]string{values}
+	}
+
+	var batches [][]string
+	for len(values) > size {
+		batches = append(batches, values[:size])
+		values = values[size:]
+	}
+	return append(batches, values)
+}
+
+// mergeTransactions concatenates the transactions, keeping only the first occurrence of every transaction ID.
+func mergeTransactions(results ...transactions) transactions {
+	seen := map[string]bool{}
+	merged := transactions{}
+	for _, txs := range results {
+		for _, tx := range txs {
+			if !seen[tx.TransactionID] {
+				seen[tx.TransactionID] = true
+				merged = append(merged, tx)
+			}
+		}
+	}
+	return merged
 }
 
 func cleanUp(resp *http.Response) {
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +20,7 @@ func TestGetLatestEvent_ServerErrors(t *testing.T) {
 	hook := logger.NewTestHook("annotations-monitoring-service")
 
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/events", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/events", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s&%s=%s", earliestTimePathVar, "-60m", lastEventPathVar, "true"), r.URL.RawQuery)
 
 		w.WriteHeader(http.StatusInternalServerError)
@@ -28,7 +32,7 @@ func TestGetLatestEvent_ServerErrors(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetLatestEvent(strings.ToLower(contentType), "60m")
+	res, err := eventReader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
 
 	assert.Equal(t, publishEvent{}, res)
 	assert.NotNil(t, err)
@@ -36,7 +40,7 @@ func TestGetLatestEvent_ServerErrors(t *testing.T) {
 	//log message format
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Failed to retrieve latest log event", hook.LastEntry().Message)
-	assert.Equal(t, fmt.Sprintf("%s/%s/events?%s=-60m\u0026lastEvent=true", eventReaderServer.URL, strings.ToLower(contentType), earliestTimePathVar), hook.LastEntry().Data["url"])
+	assert.Equal(t, fmt.Sprintf("%s/%s/events?%s=-60m\u0026lastEvent=true", eventReaderServer.URL, strings.ToLower(annotationsContentType), earliestTimePathVar), hook.LastEntry().Data["url"])
 }
 
 func TestGetLatestEvent_5xx(t *testing.T) {
@@ -52,7 +56,7 @@ func TestGetLatestEvent_5xx(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetLatestEvent(strings.ToLower(contentType), "60m")
+	res, err := eventReader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
 
 	assert.Equal(t, publishEvent{}, res)
 	assert.NotNil(t, err)
@@ -60,7 +64,7 @@ func TestGetLatestEvent_5xx(t *testing.T) {
 	//log message format
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Failed to retrieve latest log event", hook.LastEntry().Message)
-	assert.Equal(t, fmt.Sprintf("%s/%s/events?%s=-60m\u0026lastEvent=true", eventReaderServer.URL, strings.ToLower(contentType), earliestTimePathVar), hook.LastEntry().Data["url"])
+	assert.Equal(t, fmt.Sprintf("%s/%s/events?%s=-60m\u0026lastEvent=true", eventReaderServer.URL, strings.ToLower(annotationsContentType), earliestTimePathVar), hook.LastEntry().Data["url"])
 }
 
 func TestGetLatestEvent_UnmarshallingError(t *testing.T) {
@@ -68,7 +72,7 @@ func TestGetLatestEvent_UnmarshallingError(t *testing.T) {
 	hook := logger.NewTestHook("annotations-monitoring-service")
 
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/events", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/events", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s&%s=%s", earliestTimePathVar, "-60m", lastEventPathVar, "true"), r.URL.RawQuery)
 
 		w.WriteHeader(http.StatusOK)
@@ -81,7 +85,7 @@ func TestGetLatestEvent_UnmarshallingError(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetLatestEvent(strings.ToLower(contentType), "60m")
+	res, err := eventReader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
 
 	assert.Equal(t, publishEvent{}, res)
 	assert.NotNil(t, err)
@@ -89,7 +93,7 @@ func TestGetLatestEvent_UnmarshallingError(t *testing.T) {
 	//log message format
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Error unmarshalling latest publish event message", hook.LastEntry().Message)
-	assert.Equal(t, fmt.Sprintf("%s/%s/events?%s=-60m\u0026lastEvent=true", eventReaderServer.URL, strings.ToLower(contentType), earliestTimePathVar), hook.LastEntry().Data["url"])
+	assert.Equal(t, fmt.Sprintf("%s/%s/events?%s=-60m\u0026lastEvent=true", eventReaderServer.URL, strings.ToLower(annotationsContentType), earliestTimePathVar), hook.LastEntry().Data["url"])
 }
 
 func TestGetLatestEvent_Success(t *testing.T) {
@@ -97,7 +101,7 @@ func TestGetLatestEvent_Success(t *testing.T) {
 	hook := logger.NewTestHook("annotations-monitoring-service")
 
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/events", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/events", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s&%s=%s", earliestTimePathVar, "-60m", lastEventPathVar, "true"), r.URL.RawQuery)
 
 		w.WriteHeader(http.StatusOK)
@@ -110,7 +114,7 @@ func TestGetLatestEvent_Success(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetLatestEvent(strings.ToLower(contentType), "60m")
+	res, err := eventReader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
 
 	assert.Equal(t, publishEvent{}, res)
 	assert.Nil(t, err)
@@ -122,7 +126,7 @@ func TestGetTransactionsForUUIDs_UnmarshallingError(t *testing.T) {
 	hook := logger.NewTestHook("annotations-monitoring-service")
 
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s", earliestTimePathVar, "-60m"), r.URL.RawQuery)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Wrong body format"))
@@ -133,7 +137,7 @@ func TestGetTransactionsForUUIDs_UnmarshallingError(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetTransactionsForUUIDs(strings.ToLower(contentType), []string{}, "60m")
+	res, err := eventReader.GetTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{}, "60m")
 
 	assert.Nil(t, res)
 	assert.NotNil(t, err)
@@ -141,7 +145,7 @@ func TestGetTransactionsForUUIDs_UnmarshallingError(t *testing.T) {
 	//log message format
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Error unmarshalling transaction log messages", hook.LastEntry().Message)
-	assert.Equal(t, fmt.Sprintf("%s/%s/transactions?%s=-60m", eventReaderServer.URL, strings.ToLower(contentType), earliestTimePathVar), hook.LastEntry().Data["url"])
+	assert.Equal(t, fmt.Sprintf("%s/%s/transactions?%s=-60m", eventReaderServer.URL, strings.ToLower(annotationsContentType), earliestTimePathVar), hook.LastEntry().Data["url"])
 }
 
 func TestGetTransactionsForUUIDs_ServerErrors(t *testing.T) {
@@ -157,7 +161,7 @@ func TestGetTransactionsForUUIDs_ServerErrors(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetTransactionsForUUIDs(strings.ToLower(contentType), []string{}, "60m")
+	res, err := eventReader.GetTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{}, "60m")
 
 	assert.Nil(t, res)
 	assert.NotNil(t, err)
@@ -165,7 +169,7 @@ func TestGetTransactionsForUUIDs_ServerErrors(t *testing.T) {
 	//log message format
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Failed to retrieve transactions", hook.LastEntry().Message)
-	assert.Equal(t, fmt.Sprintf("%s/%s/transactions?%s=-60m", eventReaderServer.URL, strings.ToLower(contentType), earliestTimePathVar), hook.LastEntry().Data["url"])
+	assert.Equal(t, fmt.Sprintf("%s/%s/transactions?%s=-60m", eventReaderServer.URL, strings.ToLower(annotationsContentType), earliestTimePathVar), hook.LastEntry().Data["url"])
 }
 
 func TestGetTransactionsForUUIDs_5xx(t *testing.T) {
@@ -173,7 +177,7 @@ func TestGetTransactionsForUUIDs_5xx(t *testing.T) {
 	hook := logger.NewTestHook("annotations-monitoring-service")
 
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s", earliestTimePathVar, "-60m"), r.URL.RawQuery)
 		w.WriteHeader(http.StatusInternalServerError)
 	}))
@@ -183,7 +187,7 @@ func TestGetTransactionsForUUIDs_5xx(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetTransactionsForUUIDs(strings.ToLower(contentType), []string{}, "60m")
+	res, err := eventReader.GetTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{}, "60m")
 
 	assert.Nil(t, res)
 	assert.NotNil(t, err)
@@ -191,14 +195,14 @@ func TestGetTransactionsForUUIDs_5xx(t *testing.T) {
 	//log message format
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Failed to retrieve transactions", hook.LastEntry().Message)
-	assert.Equal(t, fmt.Sprintf("%s/%s/transactions?%s=-60m", eventReaderServer.URL, strings.ToLower(contentType), earliestTimePathVar), hook.LastEntry().Data["url"])
+	assert.Equal(t, fmt.Sprintf("%s/%s/transactions?%s=-60m", eventReaderServer.URL, strings.ToLower(annotationsContentType), earliestTimePathVar), hook.LastEntry().Data["url"])
 }
 
 func TestGetTransactionsForUUIDs_Success(t *testing.T) {
 
 	hook := logger.NewTestHook("annotations-monitoring-service")
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s&%s=%s&%s=%s", earliestTimePathVar, "-60m", uuidPathVar, "uuid1", uuidPathVar, "uuid2"), r.URL.RawQuery)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("[]"))
@@ -209,18 +213,38 @@ func TestGetTransactionsForUUIDs_Success(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetTransactionsForUUIDs(strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "60m")
+	res, err := eventReader.GetTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "60m")
 
 	assert.Equal(t, transactions{}, res)
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(hook.Entries))
 }
 
+func TestGetAllTransactionsForUUIDs_OnlyOpenTransactions(t *testing.T) {
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("%s=%s&%s=%s", earliestTimePathVar, "-60m", uuidPathVar, "uuid1"), r.URL.RawQuery)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[{"transaction_id":"tid_1","uuid":"uuid1","events":[{"event":"PublishStart"}]}]`))
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+	}
+
+	res, err := eventReader.GetAllTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{"uuid1"}, "60m")
+
+	// the event reader has no query for the closed transactions: they are reported missing
+	assert.Equal(t, errClosedTransactionsUnavailable, err)
+	assert.Equal(t, []string{"tid_1"}, transactionIDs(res))
+}
+
 func TestGetTransactions_Success(t *testing.T) {
 
 	hook := logger.NewTestHook("annotations-monitoring-service")
 	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(contentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
 		assert.Equal(t, fmt.Sprintf("%s=%s", earliestTimePathVar, "-60m"), r.URL.RawQuery)
 
 		w.WriteHeader(http.StatusOK)
@@ -232,9 +256,372 @@ func TestGetTransactions_Success(t *testing.T) {
 		eventReaderAddress: eventReaderServer.URL,
 	}
 
-	res, err := eventReader.GetTransactions(strings.ToLower(contentType), "60m")
+	res, err := eventReader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
 
 	assert.Equal(t, transactions{}, res)
 	assert.Nil(t, err)
 	assert.Equal(t, 0, len(hook.Entries))
 }
+
+func TestGetTransactionsBetween_Success(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+	earliest := time.Now().Add(-time.Hour).UTC()
+	at := func(d time.Duration) string { return earliest.Add(d).Format(defaultTimestampFormat) }
+
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("%s=%s", earliestTimePathVar, "-65m"), r.URL.RawQuery)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(transactions{
+			// started before the window: only its latest events are within the requested period
+			{TransactionID: "tid_before", Events: []publishEvent{{Event: mapperEvent, Time: at(time.Minute)}}},
+			// started within the window, and still going on after its end
+			{TransactionID: "tid_within", Events: []publishEvent{
+				{Event: startEvent, Time: at(20 * time.Minute)},
+				{Event: mapperEvent, Time: at(40 * time.Minute)},
+			}},
+			{TransactionID: "tid_after", Events: []publishEvent{{Event: startEvent, Time: at(40 * time.Minute)}}},
+		})
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+	}
+
+	res, err := eventReader.GetTransactionsBetween(context.Background(), strings.ToLower(annotationsContentType), earliest, earliest.Add(30*time.Minute))
+
+	assert.Nil(t, err)
+	if assert.Equal(t, []string{"tid_within"}, transactionIDs(res)) {
+		assert.Len(t, res[0].Events, 2)
+	}
+	assert.Equal(t, 0, len(hook.Entries))
+}
+
+func TestStreamTransactions_HandsOverEveryTransaction(t *testing.T) {
+
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, fmt.Sprintf("/%s/transactions", strings.ToLower(annotationsContentType)), r.URL.Path)
+		assert.Equal(t, fmt.Sprintf("%s=%s", earliestTimePathVar, "-60m"), r.URL.RawQuery)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[{"transaction_id":"tid1","uuid":"uuid1"},{"transaction_id":"tid2","uuid":"uuid2"}]`))
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+	}
+
+	var tids []string
+	err := eventReader.StreamTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m", func(tx transactionEvent) {
+		tids = append(tids, tx.TransactionID)
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tid1", "tid2"}, tids)
+}
+
+func Test_decodeTransactions(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantTids  []string
+		wantError bool
+	}{
+		{"array", `[{"transaction_id":"tid1"}, {"transaction_id":"tid2"}]`, []string{"tid1", "tid2"}, false},
+		{"empty array", `[]`, nil, false},
+		{"null", `null`, nil, false},
+		{"object", `{"transaction_id":"tid1"}`, nil, true},
+		// the transactions decoded before the error have already been handed over
+		{"truncated array", `[{"transaction_id":"tid1"}, {"transaction_id":`, []string{"tid1"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tids []string
+			err := decodeTransactions(json.NewDecoder(strings.NewReader(test.body)), func(tx transactionEvent) {
+				tids = append(tids, tx.TransactionID)
+			})
+
+			assert.Equal(t, test.wantError, err != nil)
+			assert.Equal(t, test.wantTids, tids)
+		})
+	}
+}
+
+func TestGetTransactionsForUUIDs_Batches(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	var lock sync.Mutex
+	var batches [][]string
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uuids := r.URL.Query()[uuidPathVar]
+		lock.Lock()
+		batches = append(batches, uuids)
+		lock.Unlock()
+
+		if uuids[0] == "uuid3" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// every batch returns the same duplicated transaction
+		fmt.Fprintf(w, `[{"transaction_id": "tid_%s", "uuid": "%s"}, {"transaction_id": "tid_shared", "uuid": "uuid1"}]`, uuids[0], uuids[0])
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress:   eventReaderServer.URL,
+		uuidBatchSize:        2,
+		uuidBatchConcurrency: 2,
+	}
+
+	res, err := eventReader.GetTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2", "uuid3", "uuid4", "uuid5"}, "60m")
+
+	// the batch that has failed doesn't prevent the others from being returned
+	assert.Error(t, err)
+	assert.Equal(t, []string{"tid_uuid1", "tid_shared", "tid_uuid5"}, transactionIDs(res))
+	assert.Equal(t, "Failed to retrieve transactions", hook.LastEntry().Message)
+
+	assert.ElementsMatch(t, [][]string{{"uuid1", "uuid2"}, {"uuid3", "uuid4"}, {"uuid5"}}, batches)
+}
+
+func TestStreamTransactionsForUUIDs_Batches(t *testing.T) {
+
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uuids := r.URL.Query()[uuidPathVar]
+		if uuids[0] == "uuid3" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// every batch returns the same duplicated transaction
+		fmt.Fprintf(w, `[{"transaction_id": "tid_%s", "uuid": "%s"}, {"transaction_id": "tid_shared", "uuid": "uuid1"}]`, uuids[0], uuids[0])
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress:   eventReaderServer.URL,
+		uuidBatchSize:        2,
+		uuidBatchConcurrency: 2,
+	}
+
+	// the transactions are handed over one at a time, so the handler doesn't need to be synchronised
+	var tids []string
+	err := eventReader.StreamTransactionsForUUIDs(context.Background(), strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2", "uuid3", "uuid4", "uuid5"}, "60m", func(tx transactionEvent) {
+		tids = append(tids, tx.TransactionID)
+	})
+
+	// the batch that has failed doesn't prevent the others from being handed over, and the duplicates are handed over once
+	assert.Error(t, err)
+	assert.ElementsMatch(t, []string{"tid_uuid1", "tid_shared", "tid_uuid5"}, tids)
+}
+
+func Test_batch(t *testing.T) {
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, batch([]string{"a", "b", "c"}, 2))
+	assert.Equal(t, [][]string{{"a", "b"}}, batch([]string{"a", "b"}, 2))
+	assert.Equal(t, [][]string{{"a", "b", "c"}}, batch([]string{"a", "b", "c"}, 0))
+	assert.Equal(t, [][]string{nil}, batch(nil, 2))
+}
+
+func TestGetTransactions_RetriesRetryableStatus(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	calls := 0
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[{"transaction_id":"tid_1","uuid":"uuid_1","events":[]}]`))
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+		eventReaderCalls: eventReaderCalls{
+			retryPolicy: retryPolicy{
+				maxAttempts:          3,
+				baseDelay:            time.Millisecond,
+				maxDelay:             10 * time.Millisecond,
+				retryableStatusCodes: []int{http.StatusServiceUnavailable},
+			},
+		},
+	}
+
+	txs, err := eventReader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.NoError(t, err)
+	assert.Len(t, txs, 1)
+	assert.Equal(t, 3, calls)
+
+	assert.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, "warning", hook.LastEntry().Level.String())
+	assert.Equal(t, 2, hook.LastEntry().Data["attempt"])
+	assert.Equal(t, http.StatusServiceUnavailable, hook.LastEntry().Data["status code"])
+	assert.Contains(t, hook.LastEntry().Data["url"], eventReaderServer.URL)
+}
+
+func TestGetTransactions_GivesUpAfterMaxAttempts(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	calls := 0
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+		eventReaderCalls: eventReaderCalls{
+			retryPolicy: retryPolicy{
+				maxAttempts:          2,
+				baseDelay:            time.Millisecond,
+				retryableStatusCodes: []int{http.StatusBadGateway},
+			},
+		},
+	}
+
+	_, err := eventReader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "error", hook.LastEntry().Level.String())
+	assert.Equal(t, "Failed to retrieve transactions", hook.LastEntry().Message)
+}
+
+func TestGetTransactions_DoesNotRetryOtherStatus(t *testing.T) {
+	calls := 0
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+		eventReaderCalls: eventReaderCalls{
+			retryPolicy: retryPolicy{
+				maxAttempts:          3,
+				baseDelay:            time.Millisecond,
+				retryableStatusCodes: []int{http.StatusServiceUnavailable},
+			},
+		},
+	}
+
+	_, err := eventReader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetLatestEvent_RetryAfterLongerThanMaxDelay(t *testing.T) {
+	calls := 0
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+		eventReaderCalls: eventReaderCalls{
+			retryPolicy: retryPolicy{
+				maxAttempts:          3,
+				baseDelay:            time.Millisecond,
+				maxDelay:             time.Second,
+				retryableStatusCodes: []int{http.StatusTooManyRequests},
+			},
+		},
+	}
+
+	_, err := eventReader.GetLatestEvent(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetTransactions_Timeout(t *testing.T) {
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hung event reader
+		<-r.Context().Done()
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+		eventReaderCalls: eventReaderCalls{
+			retryPolicy: retryPolicy{
+				maxAttempts: 3,
+				baseDelay:   time.Millisecond,
+			},
+			timeout: 50 * time.Millisecond,
+		},
+	}
+
+	start := time.Now()
+	_, err := eventReader.GetTransactions(context.Background(), strings.ToLower(annotationsContentType), "60m")
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestGetLatestEvent_CancelledWhileWaitingForRetry(t *testing.T) {
+	calls := 0
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{
+		eventReaderAddress: eventReaderServer.URL,
+		eventReaderCalls: eventReaderCalls{
+			retryPolicy: retryPolicy{
+				maxAttempts:          3,
+				baseDelay:            time.Minute,
+				retryableStatusCodes: []int{http.StatusServiceUnavailable},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := eventReader.GetLatestEvent(ctx, strings.ToLower(annotationsContentType), "60m")
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetTransaction(t *testing.T) {
+	// only the documented transactions query of the event reader is available
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/annotations/transactions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "-60m", r.URL.Query().Get(earliestTimePathVar))
+		w.Write([]byte(`[{"transaction_id":"tid_0","uuid":"uuid_0","events":[]},
+			{"transaction_id":"tid_1","uuid":"uuid_1","events":[{"event":"PublishStart","@time":"2017-09-22T11:50:00Z"}]}]`))
+	}))
+	defer eventReaderServer.Close()
+
+	eventReader := SplunkEventReader{eventReaderAddress: eventReaderServer.URL}
+
+	tx, err := eventReader.GetTransaction(context.Background(), "annotations", "tid_1", "60m")
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid_1", tx.UUID)
+	assert.Len(t, tx.Events, 1)
+
+	_, err = eventReader.GetTransaction(context.Background(), "annotations", "tid_2", "60m")
+	assert.Equal(t, errTransactionNotFound, err)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileEventReader answers the event reader calls from a dump of transactions, in the format returned by the event reader:
+// either a JSON array or a JSON object per line. It lets the monitoring be replayed locally over captured transactions.
+type FileEventReader struct {
+	txs transactions
+}
+
+func newFileEventReader(path string) (*FileEventReader, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := parseTransactions(b)
+	if err != nil {
+		return nil, fmt.Errorf("transactions dump %s could not be parsed: %w", path, err)
+	}
+	return &FileEventReader{txs: txs}, nil
+}
+
+func parseTransactions(b []byte) (transactions, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var txs transactions
+		err := json.Unmarshal(b, &txs)
+		return txs, err
+	}
+
+	var txs transactions
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var tx transactionEvent
+		if err := decoder.Decode(&tx); err == io.EOF {
+			return txs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+}
+
+func (r *FileEventReader) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
+	return r.GetTransactionsForUUIDs(ctx, contentType, nil, lookbackPeriod)
+}
+
+func (r *FileEventReader) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.openTransactions(contentType, func(tx transactionEvent, start time.Time) bool {
+		return !start.Before(earliest) && (len(uuids) == 0 || contains(uuids, tx.UUID))
+	}), nil
+}
+
+func (r *FileEventReader) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.matchingTransactions(contentType, func(tx transactionEvent, start time.Time) bool {
+		return !start.Before(earliest) && (len(uuids) == 0 || contains(uuids, tx.UUID))
+	}), nil
+}
+
+func (r *FileEventReader) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	return r.openTransactions(contentType, func(tx transactionEvent, start time.Time) bool {
+		return !start.Before(earliest) && start.Before(latest)
+	}), nil
+}
+
+func (r *FileEventReader) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return publishEvent{}, err
+	}
+
+	var latest publishEvent
+	var latestTime time.Time
+	for _, tx := range r.txs {
+		for _, event := range tx.Events {
+			t, err := time.Parse(defaultTimestampFormat, event.Time)
+			if err != nil || t.Before(earliest) || !strings.EqualFold(event.ContentType, contentType) {
+				continue
+			}
+			if t.After(latestTime) {
+				latest, latestTime = event, t
+			}
+		}
+	}
+
+	if latestTime.IsZero() {
+		return publishEvent{}, errors.New("Failed to retrieve latest log event")
+	}
+	return latest, nil
+}
+
+func (r *FileEventReader) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return transactionEvent{}, err
+	}
+
+	for _, tx := range r.txs {
+		start, found := dumpedTransactionStart(tx)
+		if tx.TransactionID == transactionID && found && !start.Before(earliest) && isOfContentType(tx, contentType) {
+			return tx, nil
+		}
+	}
+	return transactionEvent{}, errTransactionNotFound
+}
+
+// LookbackPeriod returns the lookback period (in minutes) covering all the dumped transactions.
+func (r *FileEventReader) LookbackPeriod() int {
+	var earliest time.Time
+	for _, tx := range r.txs {
+		if start, found := dumpedTransactionStart(tx); found && (earliest.IsZero() || start.Before(earliest)) {
+			earliest = start
+		}
+	}
+	if earliest.IsZero() {
+		return lookbackPeriodSince(time.Now())
+	}
+	return lookbackPeriodSince(earliest)
+}
+
+// LatestEventTime returns the time of the latest dumped event, or now if there is none.
+func (r *FileEventReader) LatestEventTime() time.Time {
+	var latest time.Time
+	for _, tx := range r.txs {
+		for _, event := range tx.Events {
+			if t, err := time.Parse(defaultTimestampFormat, event.Time); err == nil && t.After(latest) {
+				latest = t
+			}
+		}
+	}
+	if latest.IsZero() {
+		return time.Now()
+	}
+	return latest
+}
+
+// openTransactions returns the transactions of the content type that haven't been closed yet and are matched by the filter.
+func (r *FileEventReader) openTransactions(contentType string, filter func(tx transactionEvent, start time.Time) bool) transactions {
+	return r.matchingTransactions(contentType, func(tx transactionEvent, start time.Time) bool {
+		return !isClosed(tx) && filter(tx, start)
+	})
+}
+
+// matchingTransactions returns the transactions of the content type, closed or not, that are matched by the filter.
+func (r *FileEventReader) matchingTransactions(contentType string, filter func(tx transactionEvent, start time.Time) bool) transactions {
+	txs := transactions{}
+	for _, tx := range r.txs {
+		start, found := dumpedTransactionStart(tx)
+		if !found || !isOfContentType(tx, contentType) || !filter(tx, start) {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// earliestTime converts a lookback period, in the Splunk relative time format used by the event reader (e.g. 60m), to a time.
+func earliestTime(lookbackPeriod string) (time.Time, error) {
+	period, err := time.ParseDuration(lookbackPeriod)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid lookback period %s: %w", lookbackPeriod, err)
+	}
+	return time.Now().Add(-period), nil
+}
+
+// dumpedTransactionStart returns the start time of the transaction, or the time of its first event if there is none.
+func dumpedTransactionStart(tx transactionEvent) (time.Time, bool) {
+	if t, err := time.Parse(defaultTimestampFormat, tx.StartTime); err == nil {
+		return t, true
+	}
+
+	var start time.Time
+	for _, event := range tx.Events {
+		if t, err := time.Parse(defaultTimestampFormat, event.Time); err == nil && (start.IsZero() || t.Before(start)) {
+			start = t
+		}
+	}
+	return start, !start.IsZero()
+}
+
+func isOfContentType(tx transactionEvent, contentType string) bool {
+	for _, event := range tx.Events {
+		if strings.EqualFold(event.ContentType, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// isClosed checks whether a PublishEnd event has already been logged for the transaction.
+func isClosed(tx transactionEvent) bool {
+	for _, event := range tx.Events {
+		if event.Event == endEvent {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
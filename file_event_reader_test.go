@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTransactionsDump(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "dump")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "transactions.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func dumpedTransaction(tid, uuid string, start time.Time, events ...string) string {
+	var dumped []string
+	for i, event := range events {
+		dumped = append(dumped, fmt.Sprintf(`{"content_type":"Annotations","event":"%s","isValid":"true","level":"info","@time":"%s"}`,
+			event, start.Add(time.Duration(i)*time.Second).Format(defaultTimestampFormat)))
+	}
+	return fmt.Sprintf(`{"transaction_id":"%s","uuid":"%s","start_time":"%s","events":[%s]}`,
+		tid, uuid, start.Format(defaultTimestampFormat), strings.Join(dumped, ","))
+}
+
+func Test_FileEventReader(t *testing.T) {
+	now := time.Now().UTC()
+	dump := strings.Join([]string{
+		dumpedTransaction("tid_old", "uuid1", now.Add(-3*time.Hour), startEvent),
+		dumpedTransaction("tid_open", "uuid1", now.Add(-30*time.Minute), startEvent, "Map"),
+		dumpedTransaction("tid_other", "uuid2", now.Add(-20*time.Minute), startEvent),
+		dumpedTransaction("tid_closed", "uuid3", now.Add(-10*time.Minute), startEvent, endEvent),
+	}, "\n")
+
+	ct := strings.ToLower(annotationsContentType)
+	for name, content := range map[string]string{
+		"ndjson": dump,
+		"json":   "[" + strings.Replace(dump, "\n", ",", -1) + "]",
+	} {
+		reader, err := newFileEventReader(writeTransactionsDump(t, content))
+		assert.NoError(t, err, name)
+
+		txs, err := reader.GetTransactions(context.Background(), ct, "60m")
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"tid_open", "tid_other"}, transactionIDs(txs), name)
+
+		txs, err = reader.GetTransactionsForUUIDs(context.Background(), ct, []string{"uuid1"}, "240m")
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"tid_old", "tid_open"}, transactionIDs(txs), name)
+
+		txs, err = reader.GetAllTransactionsForUUIDs(context.Background(), ct, []string{"uuid1", "uuid3"}, "60m")
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"tid_open", "tid_closed"}, transactionIDs(txs), name)
+
+		txs, err = reader.GetTransactionsBetween(context.Background(), ct, now.Add(-4*time.Hour), now.Add(-25*time.Minute))
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"tid_old", "tid_open"}, transactionIDs(txs), name)
+
+		txs, err = reader.GetTransactions(context.Background(), "suggestions", "60m")
+		assert.NoError(t, err, name)
+		assert.Empty(t, txs, name)
+
+		event, err := reader.GetLatestEvent(context.Background(), ct, "60m")
+		assert.NoError(t, err, name)
+		assert.Equal(t, endEvent, event.Event, name)
+
+		_, err = reader.GetLatestEvent(context.Background(), ct, "1m")
+		assert.Error(t, err, name)
+
+		tx, err := reader.GetTransaction(context.Background(), ct, "tid_closed", "60m")
+		assert.NoError(t, err, name)
+		assert.Equal(t, 2, len(tx.Events), name)
+
+		_, err = reader.GetTransaction(context.Background(), ct, "tid_old", "60m")
+		assert.Equal(t, errTransactionNotFound, err, name)
+
+		assert.Equal(t, 185, reader.LookbackPeriod(), name)
+	}
+}
+
+func Test_newFileEventReader_InvalidDump(t *testing.T) {
+	_, err := newFileEventReader(writeTransactionsDump(t, `{"transaction_id": `))
+	assert.Error(t, err)
+
+	_, err = newFileEventReader(filepath.Join(os.TempDir(), "missing-transactions-dump.json"))
+	assert.Error(t, err)
+}
+
+func Test_replay(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	now := time.Now().UTC()
+	dump := strings.Join([]string{
+		dumpedTransaction("tid_1", "uuid1", now.Add(-2*time.Hour), startEvent),
+		dumpedTransaction("tid_2", "uuid1", now.Add(-time.Hour), startEvent, "Map", completenessCriteriaEvent),
+		dumpedTransaction("tid_3", "uuid2", now.Add(-time.Minute), startEvent),
+	}, "\n")
+
+	reader, err := newFileEventReader(writeTransactionsDump(t, dump))
+	assert.NoError(t, err)
+
+	sink := &collectingSink{}
+	replay(newMonitoringServices(&monitoringConfig{
+		eventReader:               reader,
+		contentTypes:              []contentTypeConfig{contentTypeRegistry[annotationsContentType]},
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 60,
+		sink:                      sink,
+	}), reader)
+
+	var closed []string
+	for _, record := range sink.collected() {
+		closed = append(closed, record.TransactionID+"="+record.Result)
+	}
+	assert.Equal(t, []string{"tid_2=completed", "tid_1=superseded"}, closed)
+}
+
+func Test_replay_OldDump(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	start := time.Now().AddDate(0, -1, 0).UTC()
+	dump := strings.Join([]string{
+		dumpedTransaction("tid_1", "uuid1", start, startEvent, "Map"),
+		dumpedTransaction("tid_2", "uuid2", start.Add(10*time.Minute), startEvent, "Map", completenessCriteriaEvent),
+		// the last transaction of the dump is still in flight
+		dumpedTransaction("tid_3", "uuid3", start.Add(50*time.Minute), startEvent),
+	}, "\n")
+
+	reader, err := newFileEventReader(writeTransactionsDump(t, dump))
+	assert.NoError(t, err)
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.timeout = 30 * time.Minute
+	sink := &collectingSink{}
+	replay(newMonitoringServices(&monitoringConfig{
+		eventReader:               reader,
+		contentTypes:              []contentTypeConfig{ct},
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 60,
+		sink:                      sink,
+	}), reader)
+
+	var closed []string
+	for _, record := range sink.collected() {
+		closed = append(closed, record.TransactionID+"="+record.Result)
+	}
+	assert.Equal(t, []string{"tid_2=completed", "tid_1=timeout"}, closed)
+	assert.Equal(t, start.Add(50*time.Minute).Format(defaultTimestampFormat), sink.collected()[1].EndTime)
+}
+
+func transactionIDs(txs transactions) []string {
+	var tids []string
+	for _, tx := range txs {
+		tids = append(tids, tx.TransactionID)
+	}
+	return tids
+}
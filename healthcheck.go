@@ -22,20 +22,43 @@ type healthService struct {
 }
 
 type healthConfig struct {
-	appSystemCode  string
-	appName        string
-	port           string
+	appSystemCode string
+	appName       string
+	port          string
+	// splunk or elasticsearch; the Splunk event reader is checked if empty
+	eventReaderType string
+	// address of the Splunk event reader application, or of the Elasticsearch cluster
 	eventReaderUrl string
+	// nil if the event reader calls aren't guarded by a circuit breaker
+	eventReaderCircuit *circuitBreaker
+	// client of the event reader, shared with the checks; the default client is used if nil
+	eventReaderClient *http.Client
+	// consumer of the publish events, with the kafka event reader
+	publishEventsConsumer *publishEventsConsumer
+	// nil if the monitoring always runs
+	leaderElection *leaderElection
 }
 
 func newHealthService(config *healthConfig) *healthService {
 	service := &healthService{config: config}
-	service.checks = []health.Check{
-		service.eventReaderCheck(),
+	switch config.eventReaderType {
+	case elasticsearchEventReaderType:
+		service.checks = []health.Check{service.elasticsearchCheck()}
+	case kafkaEventReaderType:
+		service.checks = []health.Check{service.publishEventsConsumerCheck()}
+	default:
+		service.checks = []health.Check{service.eventReaderCheck()}
 	}
-	service.httpClient = http.Client{
-		Timeout: time.Duration(10 * time.Second),
+	if config.eventReaderCircuit != nil {
+		service.checks = append(service.checks, service.eventReaderCircuitCheck())
 	}
+	if config.leaderElection != nil {
+		service.checks = append(service.checks, service.leaderElectionCheck())
+	}
+	if config.eventReaderClient != nil {
+		service.httpClient = *config.eventReaderClient
+	}
+	service.httpClient.Timeout = time.Duration(10 * time.Second)
 
 	return service
 }
@@ -52,10 +75,72 @@ func (service *healthService) eventReaderCheck() health.Check {
 }
 
 func (service *healthService) eventReaderReachabilityChecker() (string, error) {
+	if msg, err := service.checkReachable(service.config.eventReaderUrl + gtgPath); err != nil {
+		return msg, err
+	}
+	return "Splunk event reader is healthy", nil
+}
+
+func (service *healthService) elasticsearchCheck() health.Check {
+	return health.Check{
+		BusinessImpact:   "Publish events can't be read, the success of an annotation publish can't be determined.",
+		Name:             "Elasticsearch availability healthcheck",
+		PanicGuide:       "https://dewey.ft.com/annotations-monitoring-service.html",
+		Severity:         2,
+		TechnicalSummary: "The Elasticsearch/OpenSearch cluster holding the publish events is not reachable.",
+		Checker:          service.elasticsearchReachabilityChecker,
+	}
+}
+
+func (service *healthService) elasticsearchReachabilityChecker() (string, error) {
+	if msg, err := service.checkReachable(service.config.eventReaderUrl + "/_cluster/health"); err != nil {
+		return msg, err
+	}
+	return "Elasticsearch is healthy", nil
+}
+
+func (service *healthService) publishEventsConsumerCheck() health.Check {
+	return health.Check{
+		BusinessImpact:   "Publish events are not consumed, the success of an annotation publish can't be determined.",
+		Name:             "Publish events consumer healthcheck",
+		PanicGuide:       "https://dewey.ft.com/annotations-monitoring-service.html",
+		Severity:         2,
+		TechnicalSummary: "The Kafka topic of the publish events can't be consumed.",
+		Checker:          service.publishEventsConsumerChecker,
+	}
+}
+
+func (service *healthService) publishEventsConsumerChecker() (string, error) {
+	if err := service.config.publishEventsConsumer.err(); err != nil {
+		return "Consuming publish events has failed", err
+	}
+	return "Publish events are consumed", nil
+}
+
+func (service *healthService) eventReaderCircuitCheck() health.Check {
+	return health.Check{
+		BusinessImpact:   "The monitoring cycles are skipped, the success of an annotation publish can't be determined until the event reader recovers.",
+		Name:             "Event reader circuit breaker",
+		PanicGuide:       "https://dewey.ft.com/annotations-monitoring-service.html",
+		Severity:         2,
+		TechnicalSummary: "The event reader calls have failed repeatedly, they are suspended until a probe call succeeds.",
+		Checker:          service.eventReaderCircuitChecker,
+	}
+}
+
+func (service *healthService) eventReaderCircuitChecker() (string, error) {
+	state, failures := service.config.eventReaderCircuit.status()
+	if state != circuitClosed {
+		return fmt.Sprintf("Event reader circuit is %s after %d consecutive failures", state, failures), errCircuitOpen
+	}
+	return "Event reader circuit is closed", nil
+}
 
-	req, err := http.NewRequest("GET", service.config.eventReaderUrl+gtgPath, nil)
+// checkReachable checks that the URL responds successfully; the message describes the failure, if any.
+func (service *healthService) checkReachable(url string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return fmt.Sprintf("Error creating requests for url=%s", req.URL.String()), err
+		return fmt.Sprintf("Error creating requests for url=%s", url), err
 	}
 
 	resp, err := service.httpClient.Do(req)
@@ -74,7 +159,30 @@ func (service *healthService) eventReaderReachabilityChecker() (string, error) {
 		return fmt.Sprintf("Could not read payload from response for url=%s", req.URL.String()), err
 	}
 
-	return "Splunk event reader is healthy", nil
+	return "", nil
+}
+
+func (service *healthService) leaderElectionCheck() health.Check {
+	return health.Check{
+		BusinessImpact:   "No impact: the replicas on standby take over the monitoring if the leader stops.",
+		Name:             "Leader election",
+		PanicGuide:       "https://dewey.ft.com/annotations-monitoring-service.html",
+		Severity:         3,
+		TechnicalSummary: "Reports whether this replica runs the monitoring, or is on standby.",
+		Checker:          service.leaderElectionChecker,
+	}
+}
+
+// leaderElectionChecker never fails: being on standby is the expected state of all the replicas but one.
+func (service *healthService) leaderElectionChecker() (string, error) {
+	leading, leader := service.config.leaderElection.status()
+	if leading {
+		return "Leading: this replica runs the monitoring", nil
+	}
+	if leader == "" {
+		return "Standby: the leader is not known yet", nil
+	}
+	return fmt.Sprintf("Standby: %s runs the monitoring", leader), nil
 }
 
 func (service *healthService) gtgCheck() gtg.Status {
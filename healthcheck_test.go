@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/Financial-Times/go-logger"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,6 +27,24 @@ func TestEventReaderReachabilityChecker_ServiceUnavailable(t *testing.T) {
 	assert.Equal(t, fmt.Errorf("Status: %d", http.StatusServiceUnavailable), err)
 }
 
+func TestElasticsearchReachabilityChecker(t *testing.T) {
+
+	elasticsearchServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_cluster/health", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer elasticsearchServer.Close()
+
+	healthService := newHealthService(&healthConfig{eventReaderType: elasticsearchEventReaderType, eventReaderUrl: elasticsearchServer.URL})
+	assert.Equal(t, 1, len(healthService.checks))
+	assert.Equal(t, "Elasticsearch availability healthcheck", healthService.checks[0].Name)
+
+	message, err := healthService.checks[0].Checker()
+
+	assert.Equal(t, "Elasticsearch is healthy", message)
+	assert.NoError(t, err)
+}
+
 func TestEventReaderReachabilityChecker_RequestError(t *testing.T) {
 
 	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -79,3 +101,41 @@ func TestEventReader_GTG_Fails(t *testing.T) {
 	assert.NotNil(t, status.Message)
 	assert.Equal(t, false, status.GoodToGo)
 }
+
+func TestEventReaderCircuitChecker(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	breaker := newCircuitBreaker(1, time.Hour)
+	healthService := newHealthService(&healthConfig{eventReaderCircuit: breaker})
+	assert.Len(t, healthService.checks, 2)
+
+	message, err := healthService.eventReaderCircuitChecker()
+	assert.NoError(t, err)
+	assert.Equal(t, "Event reader circuit is closed", message)
+
+	breaker.record(context.Background(), errors.New("event reader is down"))
+	message, err = healthService.eventReaderCircuitChecker()
+	assert.Error(t, err)
+	assert.Equal(t, "Event reader circuit is open after 1 consecutive failures", message)
+}
+
+func TestLeaderElectionChecker(t *testing.T) {
+	election, err := newLeaderElection(newMemoryLeaderElector(&memoryLease{}, "replica-1", time.Hour), time.Hour, time.Minute, time.Second)
+	assert.NoError(t, err)
+	healthService := newHealthService(&healthConfig{leaderElection: election})
+	assert.Len(t, healthService.checks, 2)
+
+	message, err := healthService.leaderElectionChecker()
+	assert.NoError(t, err)
+	assert.Equal(t, "Standby: the leader is not known yet", message)
+
+	election.setLeading(false, "replica-2")
+	message, err = healthService.leaderElectionChecker()
+	assert.NoError(t, err)
+	assert.Equal(t, "Standby: replica-2 runs the monitoring", message)
+
+	election.setLeading(true, "replica-1")
+	message, err = healthService.leaderElectionChecker()
+	assert.NoError(t, err)
+	assert.Equal(t, "Leading: this replica runs the monitoring", message)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// httpClientConfig configures the HTTP client shared by the event reader and its healthcheck.
+// The secrets are read from files, so that they can be mounted from Kubernetes Secrets.
+type httpClientConfig struct {
+	// file holding a token sent as a bearer Authorization header
+	bearerTokenFile string
+	// basic auth credentials, used if there is no bearer token
+	basicAuthUsername     string
+	basicAuthPasswordFile string
+	// PEM bundle of the CAs trusted besides the system ones
+	caFile string
+	// PEM client certificate and key, for mTLS
+	clientCertFile string
+	clientKeyFile  string
+	// the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables if empty
+	proxyURL            string
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+func newHTTPClient(config httpClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.proxyURL != "" {
+		proxy, err := url.Parse(config.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %w", config.proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if config.maxIdleConns > 0 {
+		transport.MaxIdleConns = config.maxIdleConns
+	}
+	if config.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.maxIdleConnsPerHost
+	}
+	if config.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.idleConnTimeout
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	authorization, err := readAuthorization(config)
+	if err != nil {
+		return nil, err
+	}
+	if authorization == "" {
+		return &http.Client{Transport: transport}, nil
+	}
+	return &http.Client{Transport: authTransport{next: transport, authorization: authorization}}, nil
+}
+
+func newTLSConfig(config httpClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.caFile != "" {
+		ca, err := os.ReadFile(config.caFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool, err := x509.SystemCertPool()
+		if err != nil {
+			certPool = x509.NewCertPool()
+		}
+		if !certPool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no CA certificate could be loaded from %s", config.caFile)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	if config.clientCertFile != "" || config.clientKeyFile != "" {
+		if config.clientCertFile == "" || config.clientKeyFile == "" {
+			return nil, errors.New("both the client certificate and its key are needed for mTLS")
+		}
+		cert, err := tls.LoadX509KeyPair(config.clientCertFile, config.clientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// readAuthorization returns the Authorization header to send, empty if none has been configured.
+func readAuthorization(config httpClientConfig) (string, error) {
+	if config.bearerTokenFile != "" {
+		token, err := readSecret(config.bearerTokenFile)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+
+	if config.basicAuthUsername != "" {
+		var password string
+		if config.basicAuthPasswordFile != "" {
+			var err error
+			if password, err = readSecret(config.basicAuthPasswordFile); err != nil {
+				return "", err
+			}
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(config.basicAuthUsername+":"+password)), nil
+	}
+
+	return "", nil
+}
+
+// readSecret reads a secret from a file, without the trailing new line it is often written with.
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("the secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// authTransport adds the Authorization header to the requests that don't have one.
+type authTransport struct {
+	next          http.RoundTripper
+	authorization string
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+	// a RoundTripper mustn't modify the request it is given
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", t.authorization)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, content, 0600))
+	return path
+}
+
+func Test_newHTTPClient_Authorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(t *testing.T) httpClientConfig
+		want   string
+	}{
+		{
+			name:   "no auth",
+			config: func(t *testing.T) httpClientConfig { return httpClientConfig{} },
+			want:   "",
+		},
+		{
+			name: "bearer token",
+			config: func(t *testing.T) httpClientConfig {
+				return httpClientConfig{bearerTokenFile: writeFile(t, "token", []byte("s3cr3t\n"))}
+			},
+			want: "Bearer s3cr3t",
+		},
+		{
+			name: "basic auth",
+			config: func(t *testing.T) httpClientConfig {
+				return httpClientConfig{basicAuthUsername: "monitoring", basicAuthPasswordFile: writeFile(t, "password", []byte("s3cr3t"))}
+			},
+			want: "Basic bW9uaXRvcmluZzpzM2NyM3Q=",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+			}))
+			defer server.Close()
+
+			client, err := newHTTPClient(test.config(t))
+			require.NoError(t, err)
+
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			cleanUp(resp)
+			assert.Equal(t, test.want, authorization)
+		})
+	}
+}
+
+func Test_newHTTPClient_CA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the test server certificate isn't trusted by default
+	client, err := newHTTPClient(httpClientConfig{})
+	require.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	client, err = newHTTPClient(httpClientConfig{caFile: writeFile(t, "ca.pem", ca)})
+	require.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	cleanUp(resp)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func Test_newHTTPClient_ClientCertificate(t *testing.T) {
+	var peerCertificates int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCertificates = len(r.TLS.PeerCertificates)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	certPEM, keyPEM := selfSignedCertificate(t)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	client, err := newHTTPClient(httpClientConfig{
+		caFile:         writeFile(t, "ca.pem", ca),
+		clientCertFile: writeFile(t, "client.pem", certPEM),
+		clientKeyFile:  writeFile(t, "client-key.pem", keyPEM),
+	})
+	require.NoError(t, err)
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	cleanUp(resp)
+	assert.Equal(t, 1, peerCertificates)
+}
+
+func Test_newHTTPClient_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(t *testing.T) httpClientConfig
+	}{
+		{"missing token file", func(t *testing.T) httpClientConfig {
+			return httpClientConfig{bearerTokenFile: filepath.Join(t.TempDir(), "token")}
+		}},
+		{"empty token file", func(t *testing.T) httpClientConfig {
+			return httpClientConfig{bearerTokenFile: writeFile(t, "token", []byte("\n"))}
+		}},
+		{"invalid CA bundle", func(t *testing.T) httpClientConfig {
+			return httpClientConfig{caFile: writeFile(t, "ca.pem", []byte("not a certificate"))}
+		}},
+		{"certificate without key", func(t *testing.T) httpClientConfig {
+			certPEM, _ := selfSignedCertificate(t)
+			return httpClientConfig{clientCertFile: writeFile(t, "client.pem", certPEM)}
+		}},
+		{"invalid proxy", func(t *testing.T) httpClientConfig {
+			return httpClientConfig{proxyURL: "://proxy"}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newHTTPClient(test.config(t))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEventReaderReachabilityChecker_SharedClient(t *testing.T) {
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer splunkServer.Close()
+
+	client, err := newHTTPClient(httpClientConfig{bearerTokenFile: writeFile(t, "token", []byte("s3cr3t"))})
+	require.NoError(t, err)
+
+	healthService := newHealthService(&healthConfig{eventReaderUrl: splunkServer.URL, eventReaderClient: client})
+	_, err = healthService.eventReaderReachabilityChecker()
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, healthService.httpClient.Timeout)
+	assert.Zero(t, client.Timeout)
+}
+
+func selfSignedCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// format of the MicroTime fields of the Kubernetes API
+	kubernetesMicroTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// kubernetesLease is the coordination.k8s.io/v1 Lease resource, restricted to the fields used by the election.
+type kubernetesLease struct {
+	APIVersion string                  `json:"apiVersion"`
+	Kind       string                  `json:"kind"`
+	Metadata   kubernetesLeaseMetadata `json:"metadata"`
+	Spec       kubernetesLeaseSpec     `json:"spec"`
+}
+
+type kubernetesLeaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type kubernetesLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// expired checks whether the holder has failed to renew the lease in time.
+func (l kubernetesLease) expired(now time.Time) bool {
+	renewTime, err := time.Parse(kubernetesMicroTimeFormat, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewTime.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// kubernetesLeaderElector competes for a Lease through the Kubernetes API; concurrent updates are rejected
+// by the API server thanks to the resource version, so only one replica can acquire the lease.
+type kubernetesLeaderElector struct {
+	apiURL string
+	// the projected service account tokens are rotated, so the file is read again for every request
+	tokenFile     string
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	httpClient    *http.Client
+}
+
+// newInClusterKubernetesLeaderElector uses the service account of the pod to access the Kubernetes API.
+func newInClusterKubernetesLeaderElector(name, identity string, leaseDuration time.Duration) (*kubernetesLeaderElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("the Kubernetes leader election can only be used in a cluster")
+	}
+
+	tokenFile := serviceAccountPath + "/token"
+	if _, err := readSecret(tokenFile); err != nil {
+		return nil, err
+	}
+	namespace, err := os.ReadFile(serviceAccountPath + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("the Kubernetes API CA certificate could not be loaded")
+	}
+	httpClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certPool}},
+	}
+
+	apiURL := "https://" + net.JoinHostPort(host, port)
+	return newKubernetesLeaderElector(apiURL, tokenFile, strings.TrimSpace(string(namespace)), name, identity, leaseDuration, httpClient), nil
+}
+
+func newKubernetesLeaderElector(apiURL, tokenFile, namespace, name, identity string, leaseDuration time.Duration, httpClient *http.Client) *kubernetesLeaderElector {
+	return &kubernetesLeaderElector{
+		apiURL:        apiURL,
+		tokenFile:     tokenFile,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		httpClient:    httpClient,
+	}
+}
+
+func (e *kubernetesLeaderElector) TryAcquireOrRenew(ctx context.Context) (string, error) {
+	now := time.Now()
+
+	lease, found, err := e.getLease(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		lease = kubernetesLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   kubernetesLeaseMetadata{Name: e.name, Namespace: e.namespace},
+		}
+	} else if lease.Spec.HolderIdentity != "" && lease.Spec.HolderIdentity != e.identity && !lease.expired(now) {
+		return lease.Spec.HolderIdentity, nil
+	}
+
+	if lease.Spec.HolderIdentity != e.identity {
+		lease.Spec.HolderIdentity = e.identity
+		lease.Spec.AcquireTime = now.UTC().Format(kubernetesMicroTimeFormat)
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(e.leaseDuration.Seconds())
+	lease.Spec.RenewTime = now.UTC().Format(kubernetesMicroTimeFormat)
+
+	method, url := http.MethodPut, e.leaseURL()
+	if !found {
+		method, url = http.MethodPost, e.leasesURL()
+	}
+
+	status, err := e.send(ctx, method, url, lease, nil)
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case http.StatusOK, http.StatusCreated:
+		return e.identity, nil
+	case http.StatusConflict:
+		// another replica has updated the lease in the meantime; the next attempt tells who the leader is
+		return "", nil
+	default:
+		return "", fmt.Errorf("updating the lease %s/%s has failed with status %d", e.namespace, e.name, status)
+	}
+}
+
+func (e *kubernetesLeaderElector) Release(ctx context.Context) error {
+	lease, found, err := e.getLease(ctx)
+	if err != nil || !found || lease.Spec.HolderIdentity != e.identity {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = ""
+	status, err := e.send(ctx, http.MethodPut, e.leaseURL(), lease, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusConflict {
+		return fmt.Errorf("releasing the lease %s/%s has failed with status %d", e.namespace, e.name, status)
+	}
+	return nil
+}
+
+func (e *kubernetesLeaderElector) Identity() string {
+	return e.identity
+}
+
+func (e *kubernetesLeaderElector) getLease(ctx context.Context) (kubernetesLease, bool, error) {
+	var lease kubernetesLease
+	status, err := e.send(ctx, http.MethodGet, e.leaseURL(), nil, &lease)
+	if err != nil {
+		return kubernetesLease{}, false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return lease, true, nil
+	case http.StatusNotFound:
+		return kubernetesLease{}, false, nil
+	default:
+		return kubernetesLease{}, false, fmt.Errorf("reading the lease %s/%s has failed with status %d", e.namespace, e.name, status)
+	}
+}
+
+// send calls the Kubernetes API; the response body is only decoded for successful calls.
+func (e *kubernetesLeaderElector) send(ctx context.Context, method, url string, body interface{}, result interface{}) (int, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, &reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if e.tokenFile != "" {
+		token, err := readSecret(e.tokenFile)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanUp(resp)
+
+	if result != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return 0, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (e *kubernetesLeaderElector) leasesURL() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.apiURL, e.namespace)
+}
+
+func (e *kubernetesLeaderElector) leaseURL() string {
+	return e.leasesURL() + "/" + e.name
+}
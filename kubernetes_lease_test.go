@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLeaseAPI implements the part of the Kubernetes API used by the leader election, for a single lease.
+type fakeLeaseAPI struct {
+	sync.Mutex
+	lease   *kubernetesLease
+	version int
+	// the token currently accepted, "token" if empty
+	token string
+}
+
+func (api *fakeLeaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.Lock()
+	defer api.Unlock()
+
+	token := api.token
+	if token == "" {
+		token = "token"
+	}
+	if r.Header.Get("Authorization") != "Bearer "+token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/apis/coordination.k8s.io/v1/namespaces/upp/leases/monitoring":
+		if api.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(api.lease)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/apis/coordination.k8s.io/v1/namespaces/upp/leases":
+		if api.lease != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.store(w, r, http.StatusCreated)
+
+	case r.Method == http.MethodPut && r.URL.Path == "/apis/coordination.k8s.io/v1/namespaces/upp/leases/monitoring":
+		var lease kubernetesLease
+		json.NewDecoder(r.Body).Decode(&lease)
+		if api.lease == nil || lease.Metadata.ResourceVersion != api.lease.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.lease = &lease
+		api.version++
+		api.lease.Metadata.ResourceVersion = strconv.Itoa(api.version)
+		json.NewEncoder(w).Encode(api.lease)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *fakeLeaseAPI) store(w http.ResponseWriter, r *http.Request, status int) {
+	var lease kubernetesLease
+	json.NewDecoder(r.Body).Decode(&lease)
+	api.lease = &lease
+	api.version++
+	api.lease.Metadata.ResourceVersion = strconv.Itoa(api.version)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.lease)
+}
+
+func TestKubernetesLeaderElector(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	first := newKubernetesLeaderElector(server.URL, writeTokenFile(t, "token"), "upp", "monitoring", "pod-1", 15*time.Second, server.Client())
+	second := newKubernetesLeaderElector(server.URL, writeTokenFile(t, "token"), "upp", "monitoring", "pod-2", 15*time.Second, server.Client())
+
+	// the lease is created by the first replica
+	leader, err := first.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-1", leader)
+	assert.Equal(t, "pod-1", api.lease.Spec.HolderIdentity)
+	assert.Equal(t, 15, api.lease.Spec.LeaseDurationSeconds)
+	assert.Equal(t, 1, api.lease.Spec.LeaseTransitions)
+
+	leader, err = second.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-1", leader)
+
+	// renewal
+	leader, err = first.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-1", leader)
+	assert.Equal(t, 1, api.lease.Spec.LeaseTransitions)
+
+	// release, then takeover
+	assert.NoError(t, first.Release(context.Background()))
+	assert.Equal(t, "", api.lease.Spec.HolderIdentity)
+
+	leader, err = second.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-2", leader)
+	assert.Equal(t, 2, api.lease.Spec.LeaseTransitions)
+}
+
+func TestKubernetesLeaderElector_ExpiredLease(t *testing.T) {
+	api := &fakeLeaseAPI{lease: &kubernetesLease{
+		Metadata: kubernetesLeaseMetadata{Name: "monitoring", Namespace: "upp", ResourceVersion: "1"},
+		Spec: kubernetesLeaseSpec{
+			HolderIdentity:       "pod-1",
+			LeaseDurationSeconds: 15,
+			RenewTime:            time.Now().Add(-time.Minute).UTC().Format(kubernetesMicroTimeFormat),
+		},
+	}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	elector := newKubernetesLeaderElector(server.URL, writeTokenFile(t, "token"), "upp", "monitoring", "pod-2", 15*time.Second, server.Client())
+
+	leader, err := elector.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-2", leader)
+	assert.Equal(t, "pod-2", api.lease.Spec.HolderIdentity)
+}
+
+func TestKubernetesLeaderElector_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	elector := newKubernetesLeaderElector(server.URL, writeTokenFile(t, "token"), "upp", "monitoring", "pod-1", 15*time.Second, server.Client())
+
+	_, err := elector.TryAcquireOrRenew(context.Background())
+	assert.EqualError(t, err, "reading the lease upp/monitoring has failed with status 403")
+}
+
+func TestKubernetesLeaderElector_RotatedToken(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	tokenFile := writeTokenFile(t, "token")
+	elector := newKubernetesLeaderElector(server.URL, tokenFile, "upp", "monitoring", "pod-1", 15*time.Second, server.Client())
+
+	leader, err := elector.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-1", leader)
+
+	// the kubelet rotates the projected token, and the API server stops accepting the previous one
+	require.NoError(t, os.WriteFile(tokenFile, []byte("rotated-token\n"), 0600))
+	api.Lock()
+	api.token = "rotated-token"
+	api.Unlock()
+
+	leader, err = elector.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pod-1", leader)
+}
+
+// writeTokenFile writes a service account token file, as it is mounted in the pods.
+func writeTokenFile(t *testing.T, token string) string {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(token+"\n"), 0600))
+	return path
+}
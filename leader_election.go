@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+const (
+	kubernetesLeaderElection = "kubernetes"
+	fileLeaderElection       = "file"
+)
+
+// LeaderElector lets the replicas of the service agree on which one of them runs the monitoring.
+type LeaderElector interface {
+	// TryAcquireOrRenew acquires the leadership, or renews it if it is already held; it returns the identity of the current leader
+	TryAcquireOrRenew(ctx context.Context) (string, error)
+	// Release gives up the leadership, if it is held, so that another replica can take over right away
+	Release(ctx context.Context) error
+	Identity() string
+}
+
+type leaderElectionConfig struct {
+	electionType  string
+	identity      string
+	leaseName     string
+	lockFile      string
+	leaseDuration time.Duration
+}
+
+// newLeaderElector creates the leader elector of the given type; no elector is created when the type is empty.
+func newLeaderElector(config leaderElectionConfig) (LeaderElector, error) {
+	if config.electionType == "" {
+		return nil, nil
+	}
+
+	identity := config.identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = hostname
+	}
+
+	switch config.electionType {
+	case kubernetesLeaderElection:
+		return newInClusterKubernetesLeaderElector(config.leaseName, identity, config.leaseDuration)
+	case fileLeaderElection:
+		return newFileLeaderElector(config.lockFile, identity)
+	default:
+		return nil, fmt.Errorf("unknown leader election type: %s", config.electionType)
+	}
+}
+
+// leaderElection periodically campaigns for the leadership and notifies when it has been gained or lost.
+// As in client-go, the leader gives up once it hasn't renewed the lease within the renew deadline, which is shorter
+// than the lease duration, so that it stops before another replica can take over the expired lease.
+type leaderElection struct {
+	elector       LeaderElector
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	sync.Mutex
+	leader    string
+	leading   bool
+	lastRenew time.Time
+}
+
+func newLeaderElection(elector LeaderElector, leaseDuration, renewDeadline, retryPeriod time.Duration) (*leaderElection, error) {
+	if renewDeadline >= leaseDuration {
+		return nil, fmt.Errorf("the renew deadline (%v) must be shorter than the lease duration (%v)", renewDeadline, leaseDuration)
+	}
+	if retryPeriod >= renewDeadline {
+		return nil, fmt.Errorf("the retry period (%v) must be shorter than the renew deadline (%v)", retryPeriod, renewDeadline)
+	}
+	return &leaderElection{
+		elector:       elector,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+	}, nil
+}
+
+// status returns whether this replica is the leader and, as far as it knows, which replica is.
+func (le *leaderElection) status() (bool, string) {
+	le.Lock()
+	defer le.Unlock()
+	return le.leading, le.leader
+}
+
+// run campaigns for the leadership until the context is done; the leadership is then released.
+// onStoppedLeading is told whether the leadership has been lost, rather than given up on shutdown.
+// The callbacks are called from the campaigning goroutine, so they are never called concurrently.
+func (le *leaderElection) run(ctx context.Context, onStartedLeading func(), onStoppedLeading func(lost bool)) {
+	ticker := time.NewTicker(le.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		le.tryAcquireOrRenew(ctx, onStartedLeading, onStoppedLeading)
+
+		select {
+		case <-ctx.Done():
+			if leading, _ := le.status(); leading {
+				le.setLeading(false, "")
+				onStoppedLeading(false)
+			}
+			le.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (le *leaderElection) tryAcquireOrRenew(ctx context.Context, onStartedLeading func(), onStoppedLeading func(lost bool)) {
+	leading, _ := le.status()
+
+	// a renewal hanging past the renew deadline mustn't keep the leader running
+	electionCtx := ctx
+	if leading {
+		var cancel context.CancelFunc
+		electionCtx, cancel = context.WithDeadline(ctx, le.renewDeadlineTime())
+		defer cancel()
+	}
+	leader, err := le.elector.TryAcquireOrRenew(electionCtx)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Errorf(map[string]interface{}{
+			"identity": le.elector.Identity(),
+		}, err, "Leader election has failed.")
+
+		// the lease couldn't be renewed in time: it is about to expire, and another replica to take over
+		if leading && !time.Now().Before(le.renewDeadlineTime()) {
+			le.setLeading(false, "")
+			logger.Warnf(map[string]interface{}{
+				"identity":       le.elector.Identity(),
+				"renew_deadline": le.renewDeadline.String(),
+			}, "Leadership has been lost, stopping the monitoring.")
+			onStoppedLeading(true)
+		}
+		return
+	}
+
+	isLeader := leader == le.elector.Identity()
+	le.setLeading(isLeader, leader)
+
+	switch {
+	case isLeader && !leading:
+		logger.Infof(map[string]interface{}{"identity": le.elector.Identity()}, "Leadership has been acquired, starting the monitoring.")
+		onStartedLeading()
+	case !isLeader && leading:
+		logger.Warnf(map[string]interface{}{"identity": le.elector.Identity(), "leader": leader}, "Leadership has been lost, stopping the monitoring.")
+		onStoppedLeading(true)
+	}
+}
+
+// renewDeadlineTime returns when the leader gives up if it hasn't renewed the lease by then.
+func (le *leaderElection) renewDeadlineTime() time.Time {
+	le.Lock()
+	defer le.Unlock()
+	return le.lastRenew.Add(le.renewDeadline)
+}
+
+func (le *leaderElection) setLeading(leading bool, leader string) {
+	le.Lock()
+	defer le.Unlock()
+	le.leading = leading
+	le.leader = leader
+	if leading {
+		le.lastRenew = time.Now()
+	}
+}
+
+func (le *leaderElection) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := le.elector.Release(ctx); err != nil {
+		logger.Errorf(map[string]interface{}{
+			"identity": le.elector.Identity(),
+		}, err, "Releasing the leadership has failed.")
+	}
+}
+
+// memoryLease is a lease shared by in-process leader electors.
+type memoryLease struct {
+	sync.Mutex
+	holder string
+	expiry time.Time
+}
+
+type memoryLeaderElector struct {
+	lease         *memoryLease
+	identity      string
+	leaseDuration time.Duration
+}
+
+func newMemoryLeaderElector(lease *memoryLease, identity string, leaseDuration time.Duration) *memoryLeaderElector {
+	return &memoryLeaderElector{lease: lease, identity: identity, leaseDuration: leaseDuration}
+}
+
+func (e *memoryLeaderElector) TryAcquireOrRenew(ctx context.Context) (string, error) {
+	e.lease.Lock()
+	defer e.lease.Unlock()
+
+	now := time.Now()
+	if e.lease.holder == "" || e.lease.holder == e.identity || now.After(e.lease.expiry) {
+		e.lease.holder = e.identity
+		e.lease.expiry = now.Add(e.leaseDuration)
+	}
+	return e.lease.holder, nil
+}
+
+func (e *memoryLeaderElector) Release(ctx context.Context) error {
+	e.lease.Lock()
+	defer e.lease.Unlock()
+
+	if e.lease.holder == e.identity {
+		e.lease.holder = ""
+	}
+	return nil
+}
+
+func (e *memoryLeaderElector) Identity() string {
+	return e.identity
+}
+
+// fileLeaderElector holds an exclusive lock on a file while it is the leader; the lock is released by the OS
+// if the process dies, so it is suitable for running more instances on the same host.
+type fileLeaderElector struct {
+	sync.Mutex
+	path     string
+	identity string
+	file     *os.File
+}
+
+func newFileLeaderElector(path, identity string) (*fileLeaderElector, error) {
+	if path == "" {
+		return nil, errors.New("no lock file has been configured for the file leader election")
+	}
+	return &fileLeaderElector{path: path, identity: identity}, nil
+}
+
+func (e *fileLeaderElector) TryAcquireOrRenew(ctx context.Context) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.file != nil {
+		return e.identity, nil
+	}
+
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			// the leader writes its identity in the lock file
+			holder, _ := io.ReadAll(file)
+			return string(holder), nil
+		}
+		return "", err
+	}
+
+	if err := writeLockHolder(file, e.identity); err != nil {
+		file.Close()
+		return "", err
+	}
+	e.file = file
+	return e.identity, nil
+}
+
+func writeLockHolder(file *os.File, identity string) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(identity), 0)
+	return err
+}
+
+func (e *fileLeaderElector) Release(ctx context.Context) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.file == nil {
+		return nil
+	}
+
+	err := errors.Join(
+		e.file.Truncate(0),
+		syscall.Flock(int(e.file.Fd()), syscall.LOCK_UN),
+		e.file.Close(),
+	)
+	e.file = nil
+	return err
+}
+
+func (e *fileLeaderElector) Identity() string {
+	return e.identity
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLeaderElector(t *testing.T) {
+	elector, err := newLeaderElector(leaderElectionConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, elector)
+
+	elector, err = newLeaderElector(leaderElectionConfig{electionType: fileLeaderElection, identity: "replica-1", lockFile: filepath.Join(t.TempDir(), "lock")})
+	assert.NoError(t, err)
+	assert.IsType(t, &fileLeaderElector{}, elector)
+
+	_, err = newLeaderElector(leaderElectionConfig{electionType: "zookeeper"})
+	assert.EqualError(t, err, "unknown leader election type: zookeeper")
+}
+
+func TestMemoryLeaderElector(t *testing.T) {
+	lease := &memoryLease{}
+	first := newMemoryLeaderElector(lease, "replica-1", time.Hour)
+	second := newMemoryLeaderElector(lease, "replica-2", time.Hour)
+
+	leader, _ := first.TryAcquireOrRenew(context.Background())
+	assert.Equal(t, "replica-1", leader)
+	leader, _ = second.TryAcquireOrRenew(context.Background())
+	assert.Equal(t, "replica-1", leader)
+
+	assert.NoError(t, first.Release(context.Background()))
+	leader, _ = second.TryAcquireOrRenew(context.Background())
+	assert.Equal(t, "replica-2", leader)
+}
+
+func TestMemoryLeaderElector_ExpiredLease(t *testing.T) {
+	lease := &memoryLease{}
+	first := newMemoryLeaderElector(lease, "replica-1", time.Millisecond)
+	second := newMemoryLeaderElector(lease, "replica-2", time.Hour)
+
+	first.TryAcquireOrRenew(context.Background())
+	time.Sleep(5 * time.Millisecond)
+
+	leader, _ := second.TryAcquireOrRenew(context.Background())
+	assert.Equal(t, "replica-2", leader)
+}
+
+func TestFileLeaderElector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitoring.lock")
+	first, err := newFileLeaderElector(path, "replica-1")
+	require.NoError(t, err)
+	second, err := newFileLeaderElector(path, "replica-2")
+	require.NoError(t, err)
+
+	leader, err := first.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "replica-1", leader)
+
+	leader, err = second.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "replica-1", leader)
+
+	assert.NoError(t, first.Release(context.Background()))
+
+	leader, err = second.TryAcquireOrRenew(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "replica-2", leader)
+	assert.NoError(t, second.Release(context.Background()))
+}
+
+func TestLeaderElection_OnlyLeaderRuns(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	lease := &memoryLease{}
+	first, err := newLeaderElection(newMemoryLeaderElector(lease, "replica-1", time.Hour), time.Hour, time.Minute, 10*time.Millisecond)
+	require.NoError(t, err)
+	second, err := newLeaderElection(newMemoryLeaderElector(lease, "replica-2", time.Hour), time.Hour, time.Minute, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	running := map[string]bool{}
+	callbacks := func(identity string) (func(), func(bool)) {
+		return func() {
+				mu.Lock()
+				defer mu.Unlock()
+				running[identity] = true
+			}, func(bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				running[identity] = false
+			}
+	}
+	isRunning := func(identity string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running[identity]
+	}
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	start, stop := callbacks("replica-1")
+	go func() {
+		defer close(firstDone)
+		first.run(firstCtx, start, stop)
+	}()
+	assert.Eventually(t, func() bool { return isRunning("replica-1") }, time.Second, 5*time.Millisecond)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	secondDone := make(chan struct{})
+	start, stop = callbacks("replica-2")
+	go func() {
+		defer close(secondDone)
+		second.run(secondCtx, start, stop)
+	}()
+	assert.Eventually(t, func() bool {
+		_, leader := second.status()
+		return leader == "replica-1"
+	}, time.Second, 5*time.Millisecond)
+	assert.False(t, isRunning("replica-2"))
+
+	// the leader releases the lease on shutdown, so the follower takes over
+	stopFirst()
+	<-firstDone
+	assert.False(t, isRunning("replica-1"))
+	assert.Eventually(t, func() bool { return isRunning("replica-2") }, time.Second, 5*time.Millisecond)
+
+	leading, leader := second.status()
+	assert.True(t, leading)
+	assert.Equal(t, "replica-2", leader)
+
+	stopSecond()
+	<-secondDone
+	assert.False(t, isRunning("replica-2"))
+}
+
+func TestNewLeaderElection_Deadlines(t *testing.T) {
+	elector := newMemoryLeaderElector(&memoryLease{}, "replica-1", time.Minute)
+
+	_, err := newLeaderElection(elector, 15*time.Second, 15*time.Second, 5*time.Second)
+	assert.EqualError(t, err, "the renew deadline (15s) must be shorter than the lease duration (15s)")
+
+	_, err = newLeaderElection(elector, 15*time.Second, 10*time.Second, 10*time.Second)
+	assert.EqualError(t, err, "the retry period (10s) must be shorter than the renew deadline (10s)")
+
+	_, err = newLeaderElection(elector, 15*time.Second, 10*time.Second, 2*time.Second)
+	assert.NoError(t, err)
+}
+
+func TestLeaderElection_GivesUpAtRenewDeadline(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	elector := &unreachableLeaderElector{memoryLeaderElector: newMemoryLeaderElector(&memoryLease{}, "replica-1", time.Hour)}
+	election, err := newLeaderElection(elector, time.Hour, 50*time.Millisecond, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	stopped := make(chan bool, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go election.run(ctx, func() { close(started) }, func(lost bool) { stopped <- lost })
+	<-started
+
+	elector.setUnreachable()
+	// the leadership is given up well before the lease expires
+	select {
+	case lost := <-stopped:
+		assert.True(t, lost)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the leadership hasn't been given up at the renew deadline")
+	}
+	leading, _ := election.status()
+	assert.False(t, leading)
+}
+
+// unreachableLeaderElector fails to renew the leadership once it has been made unreachable.
+type unreachableLeaderElector struct {
+	*memoryLeaderElector
+	sync.Mutex
+	unreachable bool
+}
+
+func (e *unreachableLeaderElector) setUnreachable() {
+	e.Lock()
+	defer e.Unlock()
+	e.unreachable = true
+}
+
+func (e *unreachableLeaderElector) TryAcquireOrRenew(ctx context.Context) (string, error) {
+	e.Lock()
+	unreachable := e.unreachable
+	e.Unlock()
+	if unreachable {
+		return "", errors.New("the lease can't be reached")
+	}
+	return e.memoryLeaderElector.TryAcquireOrRenew(ctx)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,6 +14,7 @@ import (
 	"github.com/Financial-Times/go-logger"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
@@ -42,6 +46,55 @@ func main() {
 		EnvVar: "EVENT_READER_URL",
 	})
 
+	eventReaderType := app.String(cli.StringOpt{
+		Name:   "event-reader-type",
+		Value:  splunkEventReaderType,
+		Desc:   "Where the publish events are read from: splunk (through the event reader application), elasticsearch (Elasticsearch or OpenSearch index) or kafka (streamed from a topic)",
+		EnvVar: "EVENT_READER_TYPE",
+	})
+
+	elasticsearchURL := app.String(cli.StringOpt{
+		Name:   "elasticsearch-url",
+		Value:  "http://localhost:9200",
+		Desc:   "The address of the Elasticsearch/OpenSearch cluster, used by the elasticsearch event reader",
+		EnvVar: "ELASTICSEARCH_URL",
+	})
+
+	elasticsearchIndex := app.String(cli.StringOpt{
+		Name:   "elasticsearch-index",
+		Value:  "publish-events",
+		Desc:   "Name or pattern of the indices holding the publish events, used by the elasticsearch event reader",
+		EnvVar: "ELASTICSEARCH_INDEX",
+	})
+
+	elasticsearchPageSize := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-page-size",
+		Value:  1000,
+		Desc:   "How many publish events are retrieved per Elasticsearch search request",
+		EnvVar: "ELASTICSEARCH_PAGE_SIZE",
+	})
+
+	publishEventsKafkaBrokers := app.Strings(cli.StringsOpt{
+		Name:   "publish-events-kafka-brokers",
+		Value:  []string{},
+		Desc:   "Comma separated list of the Kafka brokers the publish events are consumed from, by the kafka event reader",
+		EnvVar: "PUBLISH_EVENTS_KAFKA_BROKERS",
+	})
+
+	publishEventsKafkaTopic := app.String(cli.StringOpt{
+		Name:   "publish-events-kafka-topic",
+		Value:  "",
+		Desc:   "Kafka topic the publish events are consumed from, by the kafka event reader",
+		EnvVar: "PUBLISH_EVENTS_KAFKA_TOPIC",
+	})
+
+	publishEventsKafkaConsumerGroup := app.String(cli.StringOpt{
+		Name:   "publish-events-kafka-consumer-group",
+		Value:  "annotations-monitoring-service",
+		Desc:   "Kafka consumer group of the kafka event reader; the partitions of the topic are shared between the replicas",
+		EnvVar: "PUBLISH_EVENTS_KAFKA_CONSUMER_GROUP",
+	})
+
 	port := app.String(cli.StringOpt{
 		Name:   "port",
 		Value:  "8080",
@@ -56,6 +109,13 @@ func main() {
 		EnvVar: "MAX_LOOKBACK_PERIOD_MIN",
 	})
 
+	lookbackSliceMin := app.Int(cli.IntOpt{
+		Name:   "lookback-slice-min",
+		Value:  0,
+		Desc:   "Longer lookback periods are fetched and processed in slices of this many minutes, checkpointed one by one; not sliced if 0",
+		EnvVar: "LOOKBACK_SLICE_MIN",
+	})
+
 	supersededCheckbackPeriodMin := app.Int(cli.IntOpt{
 		Name:   "defaultSupersededCheckPeriodMin",
 		Value:  4320, // fix the last 3 days' superseded transactions
@@ -63,9 +123,396 @@ func main() {
 		EnvVar: "SUPERSEDED_CHECK_PERIOD_MIN",
 	})
 
+	contentTypes := app.Strings(cli.StringsOpt{
+		Name:   "content-types",
+		Value:  []string{annotationsContentType},
+		Desc:   "Comma separated list of the content types to be monitored (Annotations, Suggestions, Concepts)",
+		EnvVar: "CONTENT_TYPES",
+	})
+
+	completenessRulesFile := app.String(cli.StringOpt{
+		Name:   "completeness-rules-file",
+		Value:  "",
+		Desc:   "Path to a JSON file defining, per content type, the events required for a transaction to be complete",
+		EnvVar: "COMPLETENESS_RULES_FILE",
+	})
+
+	transactionTimeouts := app.Strings(cli.StringsOpt{
+		Name:   "transaction-timeouts",
+		Value:  []string{},
+		Desc:   "Comma separated list of ContentType=duration pairs (e.g. Annotations=30m), after which open transactions are closed as timed out",
+		EnvVar: "TRANSACTION_TIMEOUTS",
+	})
+
+	checkpointStoreType := app.String(cli.StringOpt{
+		Name:   "checkpoint-store",
+		Value:  "",
+		Desc:   "Where to persist the monitoring progress: file or bolt; when empty, the progress is determined from the event reader on startup",
+		EnvVar: "CHECKPOINT_STORE",
+	})
+
+	checkpointPath := app.String(cli.StringOpt{
+		Name:   "checkpoint-path",
+		Value:  "checkpoints",
+		Desc:   "Directory (file store) or database file (bolt store) where the checkpoints are persisted",
+		EnvVar: "CHECKPOINT_PATH",
+	})
+
+	completionSinks := app.Strings(cli.StringsOpt{
+		Name:   "completion-sinks",
+		Value:  []string{loggerSinkType},
+		Desc:   "Comma separated list of the outputs of the closed transactions: logger, ndjson, webhook, kafka",
+		EnvVar: "COMPLETION_SINKS",
+	})
+
+	ndjsonSinkPath := app.String(cli.StringOpt{
+		Name:   "ndjson-sink-path",
+		Value:  "",
+		Desc:   "File the closed transactions are appended to, by the ndjson sink",
+		EnvVar: "NDJSON_SINK_PATH",
+	})
+
+	webhookSinkURL := app.String(cli.StringOpt{
+		Name:   "webhook-sink-url",
+		Value:  "",
+		Desc:   "URL the closed transactions are posted to, by the webhook sink",
+		EnvVar: "WEBHOOK_SINK_URL",
+	})
+
+	kafkaSinkBrokers := app.Strings(cli.StringsOpt{
+		Name:   "kafka-sink-brokers",
+		Value:  []string{},
+		Desc:   "Comma separated list of the Kafka brokers used by the kafka sink",
+		EnvVar: "KAFKA_SINK_BROKERS",
+	})
+
+	kafkaSinkTopic := app.String(cli.StringOpt{
+		Name:   "kafka-sink-topic",
+		Value:  "",
+		Desc:   "Kafka topic the closed transactions are produced to, by the kafka sink",
+		EnvVar: "KAFKA_SINK_TOPIC",
+	})
+
+	eventReaderMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "event-reader-max-attempts",
+		Value:  3,
+		Desc:   "How many times a failed event reader request is attempted",
+		EnvVar: "EVENT_READER_MAX_ATTEMPTS",
+	})
+
+	eventReaderRetryBaseDelayMs := app.Int(cli.IntOpt{
+		Name:   "event-reader-retry-base-delay-ms",
+		Value:  1000,
+		Desc:   "Delay (in milliseconds) before the first retry of a failed event reader request; it is doubled for every following retry",
+		EnvVar: "EVENT_READER_RETRY_BASE_DELAY_MS",
+	})
+
+	eventReaderRetryMaxDelayMs := app.Int(cli.IntOpt{
+		Name:   "event-reader-retry-max-delay-ms",
+		Value:  30000,
+		Desc:   "Maximum delay (in milliseconds) between the retries of a failed event reader request",
+		EnvVar: "EVENT_READER_RETRY_MAX_DELAY_MS",
+	})
+
+	eventReaderRetryJitterPercent := app.Int(cli.IntOpt{
+		Name:   "event-reader-retry-jitter-percent",
+		Value:  20,
+		Desc:   "Percentage of the retry delay that is randomised",
+		EnvVar: "EVENT_READER_RETRY_JITTER_PERCENT",
+	})
+
+	eventReaderRetryableStatusCodes := app.Ints(cli.IntsOpt{
+		Name:   "event-reader-retryable-status-codes",
+		Value:  []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		Desc:   "Comma separated list of the event reader response status codes that are retried",
+		EnvVar: "EVENT_READER_RETRYABLE_STATUS_CODES",
+	})
+
+	eventReaderTimeoutMs := app.Int(cli.IntOpt{
+		Name:   "event-reader-timeout-ms",
+		Value:  120000,
+		Desc:   "Deadline (in milliseconds) of every event reader call, retries included",
+		EnvVar: "EVENT_READER_TIMEOUT_MS",
+	})
+
+	eventReaderBearerTokenFile := app.String(cli.StringOpt{
+		Name:   "event-reader-bearer-token-file",
+		Value:  "",
+		Desc:   "File holding the token sent to the event reader as a bearer Authorization header",
+		EnvVar: "EVENT_READER_BEARER_TOKEN_FILE",
+	})
+
+	eventReaderBasicAuthUsername := app.String(cli.StringOpt{
+		Name:   "event-reader-basic-auth-username",
+		Value:  "",
+		Desc:   "Username sent to the event reader with basic auth, if there is no bearer token",
+		EnvVar: "EVENT_READER_BASIC_AUTH_USERNAME",
+	})
+
+	eventReaderBasicAuthPasswordFile := app.String(cli.StringOpt{
+		Name:   "event-reader-basic-auth-password-file",
+		Value:  "",
+		Desc:   "File holding the basic auth password of the event reader",
+		EnvVar: "EVENT_READER_BASIC_AUTH_PASSWORD_FILE",
+	})
+
+	eventReaderCAFile := app.String(cli.StringOpt{
+		Name:   "event-reader-ca-file",
+		Value:  "",
+		Desc:   "PEM bundle of the CAs trusted for the event reader, besides the system ones",
+		EnvVar: "EVENT_READER_CA_FILE",
+	})
+
+	eventReaderClientCertFile := app.String(cli.StringOpt{
+		Name:   "event-reader-client-cert-file",
+		Value:  "",
+		Desc:   "PEM client certificate presented to the event reader (mTLS)",
+		EnvVar: "EVENT_READER_CLIENT_CERT_FILE",
+	})
+
+	eventReaderClientKeyFile := app.String(cli.StringOpt{
+		Name:   "event-reader-client-key-file",
+		Value:  "",
+		Desc:   "PEM key of the client certificate presented to the event reader (mTLS)",
+		EnvVar: "EVENT_READER_CLIENT_KEY_FILE",
+	})
+
+	eventReaderProxyURL := app.String(cli.StringOpt{
+		Name:   "event-reader-proxy-url",
+		Value:  "",
+		Desc:   "Proxy of the event reader requests; taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY if empty",
+		EnvVar: "EVENT_READER_PROXY_URL",
+	})
+
+	eventReaderMaxIdleConns := app.Int(cli.IntOpt{
+		Name:   "event-reader-max-idle-conns",
+		Value:  100,
+		Desc:   "Maximum number of idle connections kept open to the event reader",
+		EnvVar: "EVENT_READER_MAX_IDLE_CONNS",
+	})
+
+	eventReaderMaxIdleConnsPerHost := app.Int(cli.IntOpt{
+		Name:   "event-reader-max-idle-conns-per-host",
+		Value:  10,
+		Desc:   "Maximum number of idle connections kept open per event reader host",
+		EnvVar: "EVENT_READER_MAX_IDLE_CONNS_PER_HOST",
+	})
+
+	eventReaderIdleConnTimeoutMs := app.Int(cli.IntOpt{
+		Name:   "event-reader-idle-conn-timeout-ms",
+		Value:  90000,
+		Desc:   "How long (in milliseconds) an idle connection to the event reader is kept open",
+		EnvVar: "EVENT_READER_IDLE_CONN_TIMEOUT_MS",
+	})
+
+	eventReaderUUIDBatchSize := app.Int(cli.IntOpt{
+		Name:   "event-reader-uuid-batch-size",
+		Value:  100,
+		Desc:   "How many UUIDs are passed per Splunk event reader request, when checking for superseded transactions; no limit if 0",
+		EnvVar: "EVENT_READER_UUID_BATCH_SIZE",
+	})
+
+	eventReaderUUIDBatchConcurrency := app.Int(cli.IntOpt{
+		Name:   "event-reader-uuid-batch-concurrency",
+		Value:  4,
+		Desc:   "How many UUID batches are requested concurrently from the Splunk event reader",
+		EnvVar: "EVENT_READER_UUID_BATCH_CONCURRENCY",
+	})
+
+	eventReaderCircuitFailureThreshold := app.Int(cli.IntOpt{
+		Name:   "event-reader-circuit-failure-threshold",
+		Value:  5,
+		Desc:   "How many consecutive failed event reader calls open its circuit, skipping the monitoring cycles; no circuit breaker if 0",
+		EnvVar: "EVENT_READER_CIRCUIT_FAILURE_THRESHOLD",
+	})
+
+	eventReaderCircuitCooldownMs := app.Int(cli.IntOpt{
+		Name:   "event-reader-circuit-cooldown-ms",
+		Value:  900000,
+		Desc:   "How long the event reader circuit stays open before a probe call is let through",
+		EnvVar: "EVENT_READER_CIRCUIT_COOLDOWN_MS",
+	})
+
+	shutdownGracePeriodMs := app.Int(cli.IntOpt{
+		Name:   "shutdown-grace-period-ms",
+		Value:  20000,
+		Desc:   "How long (in milliseconds) the running monitoring cycles are waited for on shutdown, before being cancelled",
+		EnvVar: "SHUTDOWN_GRACE_PERIOD_MS",
+	})
+
+	leaderElectionType := app.String(cli.StringOpt{
+		Name:   "leader-election",
+		Value:  "",
+		Desc:   "How the replicas elect the one running the monitoring: kubernetes (Lease) or file (lock file); when empty, the monitoring always runs",
+		EnvVar: "LEADER_ELECTION",
+	})
+
+	leaderElectionIdentity := app.String(cli.StringOpt{
+		Name:   "leader-election-identity",
+		Value:  "",
+		Desc:   "Identity of the replica in the leader election; the hostname is used when empty",
+		EnvVar: "POD_NAME",
+	})
+
+	leaderElectionLeaseName := app.String(cli.StringOpt{
+		Name:   "leader-election-lease-name",
+		Value:  "annotations-monitoring-service",
+		Desc:   "Name of the Kubernetes Lease used by the kubernetes leader election",
+		EnvVar: "LEADER_ELECTION_LEASE_NAME",
+	})
+
+	leaderElectionLockFile := app.String(cli.StringOpt{
+		Name:   "leader-election-lock-file",
+		Value:  "annotations-monitoring-service.lock",
+		Desc:   "Lock file used by the file leader election",
+		EnvVar: "LEADER_ELECTION_LOCK_FILE",
+	})
+
+	leaderElectionLeaseDurationMs := app.Int(cli.IntOpt{
+		Name:   "leader-election-lease-duration-ms",
+		Value:  15000,
+		Desc:   "How long (in milliseconds) the leadership is kept without being renewed",
+		EnvVar: "LEADER_ELECTION_LEASE_DURATION_MS",
+	})
+
+	leaderElectionRenewDeadlineMs := app.Int(cli.IntOpt{
+		Name:   "leader-election-renew-deadline-ms",
+		Value:  10000,
+		Desc:   "How long (in milliseconds) the leader keeps trying to renew the leadership before giving it up; shorter than the lease duration",
+		EnvVar: "LEADER_ELECTION_RENEW_DEADLINE_MS",
+	})
+
+	leaderElectionRetryPeriodMs := app.Int(cli.IntOpt{
+		Name:   "leader-election-retry-period-ms",
+		Value:  5000,
+		Desc:   "How often (in milliseconds) the leadership is acquired or renewed",
+		EnvVar: "LEADER_ELECTION_RETRY_PERIOD_MS",
+	})
+
+	historyWindowMin := app.Int(cli.IntOpt{
+		Name:   "history-window-min",
+		Value:  4320,
+		Desc:   "Default window (in minutes) of the content history endpoint",
+		EnvVar: "HISTORY_WINDOW_MIN",
+	})
+
+	dryRun := app.Bool(cli.BoolOpt{
+		Name:   "dry-run",
+		Value:  false,
+		Desc:   "Report the transactions that would be closed to the dry-run report, instead of logging PublishEnd events",
+		EnvVar: "DRY_RUN",
+	})
+
+	dryRunReportPath := app.String(cli.StringOpt{
+		Name:   "dry-run-report-path",
+		Value:  "dry-run-report.ndjson",
+		Desc:   "File the transactions that would be closed are appended to, in dry-run",
+		EnvVar: "DRY_RUN_REPORT_PATH",
+	})
+
 	logger.InitDefaultLogger(*appName)
 	logger.Infof(nil, "[Startup] annotations-monitoring-service is starting ")
 
+	// newMonitoringConfig sets up what the monitoring, the backfill and the replay need, with the given checkpoint store
+	// and completion sinks; the dry-run report replaces the sinks in dry-run
+	newMonitoringConfig := func(checkpointStore string, sinkTypes []string) *monitoringConfig {
+		monitoredContentTypes, err := lookupContentTypes(*contentTypes)
+		if err != nil {
+			logger.Fatalf(nil, err, "Invalid content type configuration")
+		}
+
+		monitoredContentTypes, err = loadCompletenessRules(*completenessRulesFile, monitoredContentTypes)
+		if err != nil {
+			logger.Fatalf(nil, err, "Invalid completeness rules configuration")
+		}
+
+		monitoredContentTypes, err = applyTransactionTimeouts(*transactionTimeouts, monitoredContentTypes)
+		if err != nil {
+			logger.Fatalf(nil, err, "Invalid transaction timeout configuration")
+		}
+
+		checkpoints, err := newCheckpointStore(checkpointStore, *checkpointPath)
+		if err != nil {
+			logger.Fatalf(nil, err, "Checkpoint store could not be opened")
+		}
+
+		var sink CompletionSink
+		if *dryRun {
+			logger.Infof(map[string]interface{}{"report": *dryRunReportPath}, "Running in dry-run: no PublishEnd event is logged")
+			sink, err = newDryRunReportSink(*dryRunReportPath)
+		} else {
+			sink, err = newCompletionSink(completionSinksConfig{
+				sinkTypes:    sinkTypes,
+				ndjsonPath:   *ndjsonSinkPath,
+				webhookURL:   *webhookSinkURL,
+				kafkaBrokers: *kafkaSinkBrokers,
+				kafkaTopic:   *kafkaSinkTopic,
+			})
+		}
+		if err != nil {
+			logger.Fatalf(nil, err, "Completion sinks could not be created")
+		}
+
+		eventReaderClient, err := newHTTPClient(httpClientConfig{
+			bearerTokenFile:       *eventReaderBearerTokenFile,
+			basicAuthUsername:     *eventReaderBasicAuthUsername,
+			basicAuthPasswordFile: *eventReaderBasicAuthPasswordFile,
+			caFile:                *eventReaderCAFile,
+			clientCertFile:        *eventReaderClientCertFile,
+			clientKeyFile:         *eventReaderClientKeyFile,
+			proxyURL:              *eventReaderProxyURL,
+			maxIdleConns:          *eventReaderMaxIdleConns,
+			maxIdleConnsPerHost:   *eventReaderMaxIdleConnsPerHost,
+			idleConnTimeout:       time.Duration(*eventReaderIdleConnTimeoutMs) * time.Millisecond,
+		})
+		if err != nil {
+			logger.Fatalf(nil, err, "Event reader HTTP client could not be created")
+		}
+
+		config := &monitoringConfig{
+			eventReaderURL:                  *eventReaderURL,
+			eventReaderUUIDBatchSize:        *eventReaderUUIDBatchSize,
+			eventReaderUUIDBatchConcurrency: *eventReaderUUIDBatchConcurrency,
+			eventReaderCalls: eventReaderCalls{
+				httpClient: eventReaderClient,
+				retryPolicy: retryPolicy{
+					maxAttempts:          *eventReaderMaxAttempts,
+					baseDelay:            time.Duration(*eventReaderRetryBaseDelayMs) * time.Millisecond,
+					maxDelay:             time.Duration(*eventReaderRetryMaxDelayMs) * time.Millisecond,
+					jitter:               float64(*eventReaderRetryJitterPercent) / 100,
+					retryableStatusCodes: *eventReaderRetryableStatusCodes,
+				},
+				timeout: time.Duration(*eventReaderTimeoutMs) * time.Millisecond,
+			},
+			contentTypes:              monitoredContentTypes,
+			maxLookbackPeriod:         *maxLookbackPeriodMin,
+			lookbackSlice:             time.Duration(*lookbackSliceMin) * time.Minute,
+			supersededCheckbackPeriod: *supersededCheckbackPeriodMin,
+			checkpoints:               checkpoints,
+			sink:                      sink,
+			dryRun:                    *dryRun,
+		}
+
+		switch *eventReaderType {
+		case splunkEventReaderType:
+		case elasticsearchEventReaderType:
+			config.eventReader = ElasticsearchEventReader{
+				address:          *elasticsearchURL,
+				index:            *elasticsearchIndex,
+				pageSize:         *elasticsearchPageSize,
+				eventReaderCalls: config.eventReaderCalls,
+			}
+		case kafkaEventReaderType:
+			// the transactions are built from the consumed events, and forgotten once they are closed
+			state := newStreamEventReader(time.Duration(*maxLookbackPeriodMin) * time.Minute)
+			config.eventReader = state
+			config.sink = streamingSink{next: config.sink, state: state}
+		default:
+			logger.Fatalf(nil, fmt.Errorf("unknown event reader type: %s", *eventReaderType), "Invalid event reader configuration")
+		}
+		return config
+	}
+
 	app.Action = func() {
 		logger.Infof(map[string]interface{}{
 			"System code": *appSystemCode,
@@ -73,11 +520,173 @@ func main() {
 			"Port":        *port,
 		}, "")
 
-		go serveAdminEndpoints(*appSystemCode, *appName, *port, *eventReaderURL)
-		startMonitoring(*eventReaderURL, *maxLookbackPeriodMin, *supersededCheckbackPeriodMin)
+		config := newMonitoringConfig(*checkpointStoreType, *completionSinks)
+
+		// the streamed transactions are held in memory, there is no event reader call to guard
+		if *eventReaderCircuitFailureThreshold > 0 && *eventReaderType != kafkaEventReaderType {
+			config.eventReaderCircuit = newCircuitBreaker(*eventReaderCircuitFailureThreshold, time.Duration(*eventReaderCircuitCooldownMs)*time.Millisecond)
+		}
+
+		leaderElectionLeaseDuration := time.Duration(*leaderElectionLeaseDurationMs) * time.Millisecond
+		elector, err := newLeaderElector(leaderElectionConfig{
+			electionType:  *leaderElectionType,
+			identity:      *leaderElectionIdentity,
+			leaseName:     *leaderElectionLeaseName,
+			lockFile:      *leaderElectionLockFile,
+			leaseDuration: leaderElectionLeaseDuration,
+		})
+		if err != nil {
+			logger.Fatalf(nil, err, "Leader election could not be set up")
+		}
+
+		var election *leaderElection
+		if elector != nil {
+			election, err = newLeaderElection(elector, leaderElectionLeaseDuration,
+				time.Duration(*leaderElectionRenewDeadlineMs)*time.Millisecond, time.Duration(*leaderElectionRetryPeriodMs)*time.Millisecond)
+			if err != nil {
+				logger.Fatalf(nil, err, "Leader election could not be set up")
+			}
+		}
+
+		gracePeriod := time.Duration(*shutdownGracePeriodMs) * time.Millisecond
+
+		// the services are shared by the monitors and the API, so that their cycles never overlap
+		services := newMonitoringServices(config)
+
+		// in streaming mode, the cycles only close the timed out transactions of the streamed ones and forget the stale ones
+		var consumer *publishEventsConsumer
+		if state, streaming := config.eventReader.(*streamEventReader); streaming {
+			if election != nil {
+				logger.Fatalf(nil, errors.New("leader election can't be used with the kafka event reader"), "Invalid event reader configuration")
+			}
+			consumer, err = newPublishEventsConsumer(*publishEventsKafkaBrokers, *publishEventsKafkaTopic, *publishEventsKafkaConsumerGroup, newStreamingHandler(services, state))
+			if err != nil {
+				logger.Fatalf(nil, err, "Publish events consumer could not be created")
+			}
+		}
+
+		api := newMonitoringAPI(services, time.Duration(*historyWindowMin)*time.Minute, election)
+		healthCheckedEventReader := *eventReaderURL
+		if *eventReaderType == elasticsearchEventReaderType {
+			healthCheckedEventReader = *elasticsearchURL
+		}
+		adminServer := serveAdminEndpoints(&healthConfig{
+			appSystemCode:         *appSystemCode,
+			appName:               *appName,
+			port:                  *port,
+			eventReaderType:       *eventReaderType,
+			eventReaderUrl:        healthCheckedEventReader,
+			eventReaderCircuit:    config.eventReaderCircuit,
+			eventReaderClient:     config.eventReaderCalls.httpClient,
+			publishEventsConsumer: consumer,
+			leaderElection:        election,
+		}, api)
+
+		var stopMonitoring func()
+		if election != nil {
+			stopMonitoring = monitorWhileLeading(election, services, gracePeriod)
+		} else {
+			// the monitoring is only cancelled, with its in-flight event reader requests, if it doesn't stop within the grace period
+			ctx, cancel := context.WithCancel(context.Background())
+			monitors := startMonitoring(ctx, services)
+			stopMonitoring = func() { stopMonitors(monitors, cancel, gracePeriod) }
+		}
+
+		if consumer != nil {
+			stopMonitoring = consumeWhileMonitoring(consumer, stopMonitoring)
+		}
 
 		waitForInterruptSignal()
+		logger.Infof(nil, "[Shutdown] annotations-monitoring-service is stopping")
+		shutdown(stopMonitoring, config.sink, config.checkpoints, adminServer, api)
 	}
+
+	app.Command("backfill", "Close the transactions that have started within a historical window, then exit", func(cmd *cli.Cmd) {
+		from := cmd.String(cli.StringOpt{
+			Name:  "from",
+			Value: "",
+			Desc:  "Start of the window (RFC3339)",
+		})
+		to := cmd.String(cli.StringOpt{
+			Name:  "to",
+			Value: "",
+			Desc:  "End of the window (RFC3339); now when empty",
+		})
+		chunkMin := cmd.Int(cli.IntOpt{
+			Name:  "chunk-min",
+			Value: 60,
+			Desc:  "Size (in minutes) of the chunks the window is processed in; the progress is checkpointed after every chunk",
+		})
+
+		cmd.Action = func() {
+			windowStart, err := time.Parse(time.RFC3339, *from)
+			if err != nil {
+				logger.Fatalf(nil, err, "Invalid backfill window start")
+			}
+			windowEnd := time.Now()
+			if *to != "" {
+				windowEnd, err = time.Parse(time.RFC3339, *to)
+				if err != nil {
+					logger.Fatalf(nil, err, "Invalid backfill window end")
+				}
+			}
+
+			// the backfill can only be resumed if its progress is persisted
+			storeType := *checkpointStoreType
+			if storeType == "" {
+				storeType = fileCheckpointStoreType
+			}
+			config := newMonitoringConfig(storeType, *completionSinks)
+			if _, streaming := config.eventReader.(*streamEventReader); streaming {
+				logger.Fatalf(nil, errors.New("the backfill can't use the kafka event reader"), "Invalid event reader configuration")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				waitForInterruptSignal()
+				logger.Infof(nil, "[Shutdown] backfill is stopping, it can be resumed by running it again")
+				cancel()
+			}()
+
+			err = backfill(ctx, newMonitoringServices(config), windowStart, windowEnd, time.Duration(*chunkMin)*time.Minute)
+			shutdown(func() {}, config.sink, config.checkpoints, nil, nil)
+			if err != nil {
+				logger.Fatalf(nil, err, "Backfill has not completed")
+			}
+		}
+	})
+
+	app.Command("replay", "Run a monitoring cycle over a dump of transactions instead of the event reader, then exit", func(cmd *cli.Cmd) {
+		file := cmd.String(cli.StringOpt{
+			Name:  "file",
+			Value: "",
+			Desc:  "JSON (array) or NDJSON file of transactions, in the format returned by the event reader",
+		})
+		send := cmd.Bool(cli.BoolOpt{
+			Name:  "send",
+			Value: false,
+			Desc:  "Send the closures to the configured completion sinks, instead of only logging them",
+		})
+
+		cmd.Action = func() {
+			reader, err := newFileEventReader(*file)
+			if err != nil {
+				logger.Fatalf(nil, err, "Transactions dump could not be loaded")
+			}
+
+			// the replay doesn't touch the checkpoints of the monitoring, nor, unless asked to, its sinks
+			sinkTypes := []string{loggerSinkType}
+			if *send {
+				sinkTypes = *completionSinks
+			}
+			config := newMonitoringConfig("", sinkTypes)
+			config.eventReader = reader
+
+			replay(newMonitoringServices(config), reader)
+			shutdown(func() {}, config.sink, config.checkpoints, nil, nil)
+		}
+	})
+
 	err := app.Run(os.Args)
 	if err != nil {
 		logger.Errorf(nil, err, "App could not start")
@@ -85,15 +694,16 @@ func main() {
 	}
 }
 
-func serveAdminEndpoints(appSystemCode, appName, port, eventReaderUrl string) {
-	healthService := newHealthService(&healthConfig{appSystemCode, appName, port, eventReaderUrl})
+// serveAdminEndpoints starts the admin server in the background.
+func serveAdminEndpoints(config *healthConfig, api *monitoringAPI) *http.Server {
+	healthService := newHealthService(config)
 
 	serveMux := http.NewServeMux()
 
 	hc := health.TimedHealthCheck{
 		HealthCheck: health.HealthCheck{
-			SystemCode:  appSystemCode,
-			Name:        appName,
+			SystemCode:  config.appSystemCode,
+			Name:        config.appName,
 			Description: appDescription,
 			Checks:      healthService.checks,
 		},
@@ -103,47 +713,194 @@ func serveAdminEndpoints(appSystemCode, appName, port, eventReaderUrl string) {
 	serveMux.HandleFunc(healthPath, health.Handler(hc))
 	serveMux.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(healthService.gtgCheck))
 	serveMux.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
+	serveMux.Handle(metricsPath, promhttp.Handler())
+	api.register(serveMux)
 
-	server := http.Server{
-		Addr:         ":" + port,
+	server := &http.Server{
+		Addr:         ":" + config.port,
 		Handler:      serveMux,
 		ReadTimeout:  time.Duration(120 * time.Second),
 		WriteTimeout: time.Duration(60 * time.Second),
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		logger.Fatalf(nil, err, "Unable to start service")
-	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf(nil, err, "Unable to start service")
+		}
+	}()
+	return server
 }
 
 func waitForInterruptSignal() {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	<-ch
 }
 
-func startMonitoring(eventReaderURL string, maxLookbackPeriod, supersededCheckbackPeriod int) {
-	as := AnnotationsMonitoringService{
-		eventReader: SplunkEventReader{
-			eventReaderAddress: eventReaderURL,
-		},
-		maxLookbackPeriod:         maxLookbackPeriod,
-		supersededCheckbackPeriod: supersededCheckbackPeriod,
+type monitoringConfig struct {
+	// the event reader application is called, unless another event reader is given
+	eventReader      EventReader
+	eventReaderURL   string
+	eventReaderCalls eventReaderCalls
+	// UUID batching of the Splunk event reader
+	eventReaderUUIDBatchSize        int
+	eventReaderUUIDBatchConcurrency int
+	// guards the event reader calls, if not nil
+	eventReaderCircuit        *circuitBreaker
+	contentTypes              []contentTypeConfig
+	maxLookbackPeriod         int
+	lookbackSlice             time.Duration
+	supersededCheckbackPeriod int
+	checkpoints               CheckpointStore
+	sink                      CompletionSink
+	dryRun                    bool
+}
+
+// newMonitoringServices creates a monitoring service per content type, each with its own lookback period.
+func newMonitoringServices(config *monitoringConfig) []AnnotationsMonitoringService {
+	eventReader := config.eventReader
+	if eventReader == nil {
+		eventReader = SplunkEventReader{
+			eventReaderAddress:   config.eventReaderURL,
+			eventReaderCalls:     config.eventReaderCalls,
+			uuidBatchSize:        config.eventReaderUUIDBatchSize,
+			uuidBatchConcurrency: config.eventReaderUUIDBatchConcurrency,
+		}
+	}
+	if config.eventReaderCircuit != nil {
+		eventReader = circuitBreakerEventReader{next: eventReader, breaker: config.eventReaderCircuit}
 	}
 
-	// close all the completed transactions that haven't yet been closed
-	as.CloseCompletedTransactions()
-	ticker := time.NewTicker(checkFrequency * time.Minute)
-	quit := make(chan struct{})
+	var services []AnnotationsMonitoringService
+	for _, ct := range config.contentTypes {
+		services = append(services, AnnotationsMonitoringService{
+			eventReader:               eventReader,
+			contentType:               ct,
+			maxLookbackPeriod:         config.maxLookbackPeriod,
+			supersededCheckbackPeriod: config.supersededCheckbackPeriod,
+			checkpoints:               config.checkpoints,
+			closedTxs:                 newClosedTransactionIndex(closedTransactionsTTL(config.maxLookbackPeriod, config.supersededCheckbackPeriod)),
+			sink:                      config.sink,
+			lookbackSlice:             config.lookbackSlice,
+			eventReaderCircuit:        config.eventReaderCircuit,
+			cycleLock:                 newCycleLock(),
+			dryRun:                    config.dryRun,
+		})
+	}
+	return services
+}
+
+func startMonitoring(ctx context.Context, services []AnnotationsMonitoringService) []*monitor {
+	var monitors []*monitor
+	for _, service := range services {
+		m := newMonitor(service, checkFrequency*time.Minute)
+		m.start(ctx)
+		monitors = append(monitors, m)
+	}
+	return monitors
+}
+
+// monitorWhileLeading runs the monitoring only while this replica is the leader; the returned function
+// stops the monitoring and gives up the leadership.
+func monitorWhileLeading(election *leaderElection, services []AnnotationsMonitoringService, gracePeriod time.Duration) func() {
+	var monitors []*monitor
+	var cancelMonitoring context.CancelFunc
+
+	ctx, stopElection := context.WithCancel(context.Background())
+	done := make(chan struct{})
 	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				as.CloseCompletedTransactions()
-			case <-quit:
-				ticker.Stop()
-				return
+		defer close(done)
+		election.run(ctx, func() {
+			var monitoringCtx context.Context
+			monitoringCtx, cancelMonitoring = context.WithCancel(context.Background())
+			monitors = startMonitoring(monitoringCtx, services)
+		}, func(lost bool) {
+			// another replica might already be monitoring, so the cycles in flight are cancelled right away
+			if lost {
+				cancelMonitoring()
 			}
-		}
+			stopMonitors(monitors, cancelMonitoring, gracePeriod)
+			monitors = nil
+		})
 	}()
+
+	return func() {
+		stopElection()
+		<-done
+	}
+}
+
+// consumeWhileMonitoring consumes the publish events in the background; the returned function stops the consumption
+// before stopping the monitoring.
+func consumeWhileMonitoring(consumer *publishEventsConsumer, stopMonitoring func()) func() {
+	ctx, stopConsuming := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.run(ctx)
+	}()
+
+	return func() {
+		stopConsuming()
+		<-done
+		stopMonitoring()
+	}
+}
+
+// shutdown stops the admin server, if there is one, and the manual runs, so that no cycle can be started through it,
+// then drains the monitoring and releases the sinks and the checkpoint store.
+func shutdown(stopMonitoring func(), sink CompletionSink, checkpoints CheckpointStore, adminServer *http.Server, api *monitoringAPI) {
+	if api != nil {
+		api.stop()
+	}
+
+	if adminServer != nil {
+		ctx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logger.Errorf(nil, err, "Shutting down the admin server has failed.")
+		}
+	}
+
+	stopMonitoring()
+
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			logger.Errorf(nil, err, "Flushing the completion sinks has failed.")
+		}
+	}
+
+	if checkpoints != nil {
+		if err := checkpoints.Close(); err != nil {
+			logger.Errorf(nil, err, "Closing the checkpoint store has failed.")
+		}
+	}
+
+	logger.Infof(nil, "[Shutdown] annotations-monitoring-service has stopped")
+}
+
+// backfill runs the backfill of every content type in turn.
+func backfill(ctx context.Context, services []AnnotationsMonitoringService, from, to time.Time, chunk time.Duration) error {
+	for _, service := range services {
+		if err := runBackfill(ctx, service, from, to, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay runs a single cycle per content type, covering the whole dump; the transactions are timed out
+// as if the cycle ran right after the latest dumped event.
+func replay(services []AnnotationsMonitoringService, dump *FileEventReader) {
+	lookbackPeriod, replayedAt := dump.LookbackPeriod(), dump.LatestEventTime()
+	for _, service := range services {
+		service.lookbackOverride = lookbackPeriod
+		service.replayedAt = replayedAt
+		summary := service.CloseCompletedTransactions(context.Background())
+		logger.Infof(map[string]interface{}{
+			"content_type": summary.ContentType,
+			"transactions": summary.Transactions,
+			"closed":       summary.Closed,
+		}, "Replay has finished.")
+	}
 }
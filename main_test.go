@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger"
 	"github.com/stretchr/testify/assert"
@@ -15,6 +17,81 @@ func Test_StartMonitoring(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer eventReaderServer.Close()
-	startMonitoring(eventReaderServer.URL, 60, 30)
-	assert.NotEmpty(t, hook.Entries)
+	ctx, cancel := context.WithCancel(context.Background())
+	monitors := startMonitoring(ctx, newMonitoringServices(&monitoringConfig{
+		eventReaderURL:            eventReaderServer.URL,
+		contentTypes:              []contentTypeConfig{contentTypeRegistry[annotationsContentType]},
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 30,
+	}))
+	stopMonitors(monitors, cancel, time.Second)
+
+	assert.Len(t, monitors, 1)
+	assert.Equal(t, "Monitoring has stopped.", hook.LastEntry().Message)
+}
+
+func Test_MonitorWhileLeading_CancelsCyclesWhenLeadershipIsLost(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	lease := &memoryLease{}
+	election, err := newLeaderElection(newMemoryLeaderElector(lease, "replica-1", time.Hour), time.Hour, time.Minute, 10*time.Millisecond)
+	assert.NoError(t, err)
+
+	reader := newBlockingEventReader()
+	stopMonitoring := monitorWhileLeading(election, []AnnotationsMonitoringService{{
+		eventReader:       reader,
+		contentType:       contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod: 60,
+	}}, time.Hour)
+	defer stopMonitoring()
+	<-reader.called
+
+	// another replica takes over the lease
+	lease.Lock()
+	lease.holder = "replica-2"
+	lease.expiry = time.Now().Add(time.Hour)
+	lease.Unlock()
+
+	// the cycle in flight is cancelled without waiting for the grace period
+	select {
+	case <-reader.cancelled:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the monitoring cycle hasn't been cancelled when the leadership has been lost")
+	}
+}
+
+func Test_Shutdown_StopsManualRunsBeforeClosingTheSinks(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	reader := newBlockingEventReader()
+	api := newMonitoringAPI([]AnnotationsMonitoringService{{
+		eventReader:       reader,
+		contentType:       contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod: 60,
+	}}, time.Hour, nil)
+	serveMux := http.NewServeMux()
+	api.register(serveMux)
+
+	go serveMux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/__monitor/run", nil))
+	<-reader.called
+
+	sink := &closeCheckingSink{closing: func() {
+		select {
+		case <-reader.cancelled:
+		default:
+			assert.Fail(t, "the sinks have been closed while a manual run was in progress")
+		}
+	}}
+	shutdown(func() {}, sink, nil, nil, api)
+	assert.True(t, sink.closed)
+}
+
+type closeCheckingSink struct {
+	recordingSink
+	closing func()
+}
+
+func (s *closeCheckingSink) Close() error {
+	s.closing()
+	return s.recordingSink.Close()
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsPath      = "/metrics"
+	metricsNamespace = "annotations_monitoring"
+
+	completedResult  = "completed"
+	invalidResult    = "invalid"
+	supersededResult = "superseded"
+	failedResult     = "failed"
+	timeoutResult    = "timeout"
+	skippedResult    = "skipped"
+
+	// superseded transactions have no validity information
+	unknownValidity = "unknown"
+)
+
+var (
+	transactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "transaction_duration_seconds",
+		Help:      "Duration of the closed transactions, from their start until their end event.",
+		Buckets:   []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 4 * 3600},
+	}, []string{"content_type", "is_valid"})
+
+	transactionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "transactions_total",
+		Help:      "Number of transactions closed (completed, invalid, superseded, failed, timeout) or skipped because they couldn't be closed.",
+	}, []string{"content_type", "result"})
+
+	suppressedDuplicatesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "suppressed_duplicates_total",
+		Help:      "Number of closures that have been suppressed, because the transaction had already been closed.",
+	}, []string{"content_type"})
+
+	eventReaderErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_reader_errors_total",
+		Help:      "Number of failed event reader calls.",
+	}, []string{"content_type", "operation"})
+
+	lookbackPeriodMinutes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "lookback_period_minutes",
+		Help:      "Lookback period used by the last monitoring cycle.",
+	}, []string{"content_type"})
+
+	eventReaderCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_reader_circuit_state",
+		Help:      "State of the event reader circuit breaker: 1 for the current state (closed, open, half-open), 0 for the others.",
+	}, []string{"state"})
+)
+
+func init() {
+	prometheus.MustRegister(transactionDuration, transactionsTotal, suppressedDuplicatesTotal, eventReaderErrorsTotal, lookbackPeriodMinutes, eventReaderCircuitState)
+}
+
+// recordClosedTransaction records the closure of a transaction with the given result.
+func recordClosedTransaction(contentType, result, isValid string, duration time.Duration) {
+	if isValid == "" {
+		isValid = unknownValidity
+	}
+	transactionsTotal.WithLabelValues(contentType, result).Inc()
+	transactionDuration.WithLabelValues(contentType, isValid).Observe(duration.Seconds())
+}
+
+func recordSkippedTransaction(contentType string) {
+	transactionsTotal.WithLabelValues(contentType, skippedResult).Inc()
+}
+
+func recordEventReaderError(contentType, operation string) {
+	eventReaderErrorsTotal.WithLabelValues(contentType, operation).Inc()
+}
+
+func recordCircuitState(state string) {
+	for _, s := range []string{circuitClosed, circuitOpen, circuitHalfOpen} {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		eventReaderCircuitState.WithLabelValues(s).Set(value)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_CloseCompletedTransactions_RecordsMetrics(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	// a dedicated content type, so that the metrics are not affected by other tests
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.name = "MetricsTest"
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		maxLookbackPeriod:         120,
+		supersededCheckbackPeriod: 60,
+	}
+
+	txs := transactions{
+		// superseded
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:45:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: ct.name, Time: "2017-09-22T11:45:00.00000000Z", Event: startEvent},
+			}},
+		// invalid
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid2",
+			StartTime:     "2017-09-22T11:50:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: ct.name, Time: "2017-09-22T11:50:00.00000000Z", Event: startEvent},
+				{ContentType: ct.name, Time: "2017-09-22T11:50:02.00000000Z", IsValid: "false", Event: "Map"},
+			}},
+		// completed
+		transactionEvent{
+			TransactionID: "tid3",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:55:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: ct.name, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
+				{ContentType: ct.name, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: ct.name, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+		// skipped
+		transactionEvent{
+			TransactionID: "tid4",
+			UUID:          "uuid4",
+			StartTime:     "2017-09-22T11:56:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: ct.name, Time: "2017-09-22T11:56:00.00000000Z", Event: startEvent},
+				{ContentType: ct.name, Time: "2017-09-22T11:56:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: ct.name, Time: "2017-09-22 11:56:04", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+	}
+
+	readerMock.On("GetLatestEvent", "metricstest", mock.AnythingOfType("string")).
+		Return(publishEvent{}, errors.New("unavailable")).
+		On("GetTransactions", "metricstest", "120m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", "metricstest", mock.Anything, "180m").
+		Return(txs, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(transactionsTotal.WithLabelValues(ct.name, completedResult)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(transactionsTotal.WithLabelValues(ct.name, invalidResult)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(transactionsTotal.WithLabelValues(ct.name, supersededResult)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(transactionsTotal.WithLabelValues(ct.name, skippedResult)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(eventReaderErrorsTotal.WithLabelValues(ct.name, "GetLatestEvent")))
+	assert.Equal(t, float64(120), testutil.ToFloat64(lookbackPeriodMinutes.WithLabelValues(ct.name)))
+
+	assert.Equal(t, uint64(1), histogramSampleCount(t, ct.name, "true"))
+	assert.Equal(t, uint64(1), histogramSampleCount(t, ct.name, "false"))
+	assert.Equal(t, uint64(1), histogramSampleCount(t, ct.name, unknownValidity))
+}
+
+func Test_CloseCompletedTransactions_DryRunRecordsNoMetrics(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.name = "DryRunMetricsTest"
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		maxLookbackPeriod:         120,
+		supersededCheckbackPeriod: 60,
+		closedTxs:                 newClosedTransactionIndex(time.Hour),
+		sink:                      &recordingSink{},
+		dryRun:                    true,
+	}
+	am.closedTxs.markClosed("tid1", time.Now())
+
+	txs := transactions{
+		// closed by an earlier cycle
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:55:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: ct.name, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
+				{ContentType: ct.name, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: ct.name, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+		// skipped
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid2",
+			StartTime:     "2017-09-22T11:56:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: ct.name, Time: "2017-09-22T11:56:00.00000000Z", Event: startEvent},
+				{ContentType: ct.name, Time: "2017-09-22T11:56:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: ct.name, Time: "2017-09-22 11:56:04", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+	}
+
+	readerMock.On("GetLatestEvent", "dryrunmetricstest", mock.AnythingOfType("string")).
+		Return(publishEvent{}, errors.New("unavailable")).
+		On("GetTransactions", "dryrunmetricstest", "120m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", "dryrunmetricstest", mock.Anything, "180m").
+		Return(txs, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+	assert.Equal(t, 1, am.closedTxs.suppressedDuplicates())
+	assert.Equal(t, float64(0), testutil.ToFloat64(suppressedDuplicatesTotal.WithLabelValues(ct.name)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(transactionsTotal.WithLabelValues(ct.name, skippedResult)))
+}
+
+func histogramSampleCount(t *testing.T, contentType, isValid string) uint64 {
+	var m dto.Metric
+	assert.NoError(t, transactionDuration.WithLabelValues(contentType, isValid).(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func Test_MetricsEndpoint(t *testing.T) {
+	recordClosedTransaction("EndpointTest", completedResult, "true", 0)
+
+	server := httptest.NewServer(promhttp.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + metricsPath)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `annotations_monitoring_transactions_total{content_type="EndpointTest",result="completed"} 1`)
+	assert.Contains(t, string(body), `annotations_monitoring_transaction_duration_seconds_count{content_type="EndpointTest",is_valid="true"} 1`)
+}
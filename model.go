@@ -1,5 +1,10 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type publishEvent struct {
 	ContentType     string `json:"content_type"`
 	Environment     string `json:"environment"`
@@ -8,11 +13,32 @@ type publishEvent struct {
 	Level           string `json:"level"`
 	MonitoringEvent string `json:"monitoring_event"`
 	Msg             string `json:"msg"`
+	Outcome         string `json:"outcome,omitempty"`
 	Platform        string `json:"platform"`
 	ServiceName     string `json:"service_name"`
 	Time            string `json:"@time"`
 	TransactionID   string `json:"transaction_id"`
 	UUID            string `json:"uuid"`
+
+	// the time of the event, parsed once when the event is decoded
+	at time.Time
+}
+
+func (e *publishEvent) UnmarshalJSON(b []byte) error {
+	type decodedEvent publishEvent
+	if err := json.Unmarshal(b, (*decodedEvent)(e)); err != nil {
+		return err
+	}
+	e.at = parseTime(e.Time)
+	return nil
+}
+
+// parsedTime returns the time of the event; the events that haven't been decoded (e.g. built by the service) are parsed on demand.
+func (e publishEvent) parsedTime() time.Time {
+	if e.at.IsZero() {
+		return parseTime(e.Time)
+	}
+	return e.at
 }
 
 type transactionEvent struct {
@@ -22,6 +48,33 @@ type transactionEvent struct {
 	EventCount    int            `json:"eventcount"`
 	StartTime     string         `json:"start_time"`
 	Events        []publishEvent `json:"events"`
+
+	// the start time, parsed once when the transaction is decoded
+	start time.Time
+}
+
+func (tx *transactionEvent) UnmarshalJSON(b []byte) error {
+	type decodedTransaction transactionEvent
+	if err := json.Unmarshal(b, (*decodedTransaction)(tx)); err != nil {
+		return err
+	}
+	tx.start = parseTime(tx.StartTime)
+	return nil
+}
+
+// startedAt returns the start time of the transaction, parsed on demand if the transaction hasn't been decoded.
+func (tx transactionEvent) startedAt() time.Time {
+	if tx.start.IsZero() {
+		return parseTime(tx.StartTime)
+	}
+	return tx.start
+}
+
+// parseTime parses the timestamps of the events: the RFC3339 ones may have their fraction trimmed, so that they can't be
+// compared as strings. A missing or malformed timestamp is the zero time, which is earlier than all the others.
+func parseTime(timestamp string) time.Time {
+	t, _ := time.Parse(defaultTimestampFormat, timestamp)
+	return t
 }
 
 type transactions []transactionEvent
@@ -35,7 +88,7 @@ func (a transactions) Swap(i, j int) {
 }
 
 func (a transactions) Less(i, j int) bool {
-	return a[i].StartTime < a[j].StartTime
+	return a[i].startedAt().Before(a[j].startedAt())
 }
 
 // ***********************************
@@ -46,6 +99,16 @@ type completedTransactionEvent struct {
 	Duration      string
 	StartTime     string
 	EndTime       string
+
+	// the start time, parsed once when the transaction has been evaluated
+	start time.Time
+}
+
+func (c completedTransactionEvent) startedAt() time.Time {
+	if c.start.IsZero() {
+		return parseTime(c.StartTime)
+	}
+	return c.start
 }
 
 type completedTransactionEvents []completedTransactionEvent
@@ -59,5 +122,5 @@ func (a completedTransactionEvents) Swap(i, j int) {
 }
 
 func (a completedTransactionEvents) Less(i, j int) bool {
-	return a[i].StartTime < a[j].StartTime
+	return a[i].startedAt().Before(a[j].startedAt())
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+// monitor runs the monitoring cycles of a content type, every checkFrequency minutes, until it is stopped.
+type monitor struct {
+	service  AnnotationsMonitoringService
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// only accessed by the monitoring goroutine, or once it is done
+	cycles    int
+	lastCycle cycleSummary
+}
+
+func newMonitor(service AnnotationsMonitoringService, interval time.Duration) *monitor {
+	return &monitor{
+		service:  service,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start runs the first cycle right away, then the following ones on every tick.
+func (m *monitor) start(ctx context.Context) {
+	go func() {
+		defer close(m.done)
+
+		m.service.RestoreClosedTransactions()
+
+		// close all the completed transactions that haven't yet been closed
+		m.runCycle(ctx)
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.runCycle(ctx)
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *monitor) runCycle(ctx context.Context) {
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+
+	// the error only tells that the cycle has been cancelled while waiting for an on demand one
+	m.lastCycle, _ = m.service.RunCycle(ctx)
+	m.cycles++
+}
+
+// stopScheduling prevents new cycles from being started; the running one, if any, carries on.
+func (m *monitor) stopScheduling() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// logSummary reports what the monitor has processed; it should only be called once the monitor is done.
+func (m *monitor) logSummary() {
+	logger.Infof(map[string]interface{}{
+		"content_type":            m.service.contentType.name,
+		"cycles":                  m.cycles,
+		"last_cycle_transactions": m.lastCycle.Transactions,
+		"last_cycle_closed":       m.lastCycle.Closed,
+		"last_cycle_unprocessed":  m.lastCycle.Unprocessed,
+		"last_cycle_cancelled":    m.lastCycle.Cancelled,
+		"last_cycle_failed":       m.lastCycle.Failed,
+	}, "Monitoring has stopped.")
+}
+
+// stopMonitors stops scheduling new cycles and waits for the running ones to finish; if they don't finish
+// within the grace period, they are cancelled, together with their in-flight event reader requests.
+func stopMonitors(monitors []*monitor, cancel context.CancelFunc, gracePeriod time.Duration) {
+	for _, m := range monitors {
+		m.stopScheduling()
+	}
+
+	grace := time.NewTimer(gracePeriod)
+	defer grace.Stop()
+
+	for _, m := range monitors {
+		select {
+		case <-m.done:
+		case <-grace.C:
+			logger.Warnf(map[string]interface{}{
+				"grace_period": gracePeriod.String(),
+			}, "Monitoring cycles haven't finished within the grace period, cancelling them.")
+			cancel()
+			<-m.done
+		}
+	}
+	cancel()
+
+	for _, m := range monitors {
+		<-m.done
+		m.logSummary()
+	}
+}
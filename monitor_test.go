@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_StopMonitors_DrainsRunningCycle(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	started := make(chan struct{})
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "60m").
+		Run(func(mock.Arguments) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+		}).
+		Return(transactions{}, nil)
+
+	m := newMonitor(AnnotationsMonitoringService{
+		eventReader:       readerMock,
+		contentType:       contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod: 60,
+	}, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.start(ctx)
+	<-started
+	stopMonitors([]*monitor{m}, cancel, time.Second)
+
+	readerMock.AssertExpectations(t)
+	assert.Equal(t, 1, m.cycles)
+	assert.False(t, m.lastCycle.Cancelled)
+	assert.Equal(t, "info", hook.LastEntry().Level.String())
+	assert.Equal(t, "Monitoring has stopped.", hook.LastEntry().Message)
+	assert.Equal(t, false, hook.LastEntry().Data["last_cycle_cancelled"])
+}
+
+func Test_StopMonitors_CancelsCycleAfterGracePeriod(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	reader := newBlockingEventReader()
+	m := newMonitor(AnnotationsMonitoringService{
+		eventReader:       reader,
+		contentType:       contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod: 60,
+	}, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.start(ctx)
+	<-reader.called
+	stopMonitors([]*monitor{m}, cancel, 50*time.Millisecond)
+
+	assert.True(t, m.lastCycle.Cancelled)
+	assert.Equal(t, "Monitoring has stopped.", hook.LastEntry().Message)
+	assert.Equal(t, true, hook.LastEntry().Data["last_cycle_cancelled"])
+
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Monitoring cycles haven't finished within the grace period, cancelling them." {
+			warned = true
+		}
+	}
+	assert.True(t, warned)
+}
+
+func Test_CloseCompletedTransactions_StopsBetweenTransactionsWhenCancelled(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &recordingSink{}
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "60m").
+		Run(func(mock.Arguments) { cancel() }).
+		Return(transactions{
+			{TransactionID: "tid1", UUID: "uuid1"},
+			{TransactionID: "tid2", UUID: "uuid2"},
+		}, nil)
+
+	am := AnnotationsMonitoringService{
+		eventReader:       readerMock,
+		contentType:       contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod: 60,
+		sink:              sink,
+	}
+
+	summary := am.CloseCompletedTransactions(ctx)
+
+	assert.True(t, summary.Cancelled)
+	assert.Equal(t, 2, summary.Transactions)
+	assert.Equal(t, 2, summary.Unprocessed)
+	assert.Empty(t, sink.records)
+	readerMock.AssertNotCalled(t, "GetTransactionsForUUIDs", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// blockingEventReader is a hung event reader: its calls only return when they are cancelled.
+type blockingEventReader struct {
+	once          sync.Once
+	called        chan struct{}
+	cancelledOnce sync.Once
+	cancelled     chan struct{}
+}
+
+func newBlockingEventReader() *blockingEventReader {
+	return &blockingEventReader{called: make(chan struct{}), cancelled: make(chan struct{})}
+}
+
+func (r *blockingEventReader) block(ctx context.Context) error {
+	r.once.Do(func() { close(r.called) })
+	<-ctx.Done()
+	r.cancelledOnce.Do(func() { close(r.cancelled) })
+	return ctx.Err()
+}
+
+func (r *blockingEventReader) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
+	return nil, r.block(ctx)
+}
+
+func (r *blockingEventReader) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	return nil, r.block(ctx)
+}
+
+func (r *blockingEventReader) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	return nil, r.block(ctx)
+}
+
+func (r *blockingEventReader) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	return nil, r.block(ctx)
+}
+
+func (r *blockingEventReader) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
+	return publishEvent{}, r.block(ctx)
+}
+
+func (r *blockingEventReader) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	return transactionEvent{}, r.block(ctx)
+}
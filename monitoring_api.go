@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+// monitoringAPI exposes the monitoring on the admin endpoints.
+type monitoringAPI struct {
+	services []AnnotationsMonitoringService
+	// default window of the content history
+	historyWindow time.Duration
+	// nil if the monitoring always runs
+	election *leaderElection
+
+	// the manual runs are cancelled, and waited for, on shutdown, so that they never send to a closed sink
+	stopping   context.Context
+	cancelRuns context.CancelFunc
+	sync.Mutex
+	stopped bool
+	runs    sync.WaitGroup
+}
+
+func newMonitoringAPI(services []AnnotationsMonitoringService, historyWindow time.Duration, election *leaderElection) *monitoringAPI {
+	stopping, cancelRuns := context.WithCancel(context.Background())
+	return &monitoringAPI{services: services, historyWindow: historyWindow, election: election, stopping: stopping, cancelRuns: cancelRuns}
+}
+
+// stop rejects the manual runs from now on, and cancels the running ones; it returns once they have finished.
+func (api *monitoringAPI) stop() {
+	api.Lock()
+	api.stopped = true
+	api.Unlock()
+
+	api.cancelRuns()
+	api.runs.Wait()
+}
+
+// startRun tracks a manual run, unless the API has been stopped; the run is cancelled along with the request,
+// or when the API is stopped. The returned function must be called once the run has finished.
+func (api *monitoringAPI) startRun(ctx context.Context) (context.Context, func(), bool) {
+	api.Lock()
+	defer api.Unlock()
+	if api.stopped {
+		return nil, nil, false
+	}
+	api.runs.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stopCancelling := context.AfterFunc(api.stopping, cancel)
+	return ctx, func() {
+		stopCancelling()
+		cancel()
+		api.runs.Done()
+	}, true
+}
+
+func (api *monitoringAPI) register(serveMux *http.ServeMux) {
+	serveMux.HandleFunc("GET /transactions/{tid}", api.getTransaction)
+	serveMux.HandleFunc("GET /content/{uuid}/history", api.getContentHistory)
+	serveMux.HandleFunc("POST /__monitor/run", api.runCycle)
+}
+
+type cycleReport struct {
+	cycleSummary
+	DryRun bool `json:"dry_run"`
+	// in dry-run, the transactions that would have been closed
+	ClosedTransactions []closedTransaction `json:"closed_transactions"`
+	// why the cycle couldn't run
+	Error string `json:"error,omitempty"`
+}
+
+type closedTransaction struct {
+	TransactionID string `json:"transaction_id"`
+	UUID          string `json:"uuid"`
+	Result        string `json:"result"`
+	Rule          string `json:"rule"`
+}
+
+// runCycle runs a monitoring cycle right away, for every monitored content type unless one is given through the
+// contentType parameter; the lookback parameter (in minutes) overrides the lookback period of the cycle.
+// With dryRun=true, the closures are only reported in the response.
+func (api *monitoringAPI) runCycle(w http.ResponseWriter, r *http.Request) {
+	if api.election != nil {
+		if leading, _ := api.election.status(); !leading {
+			writeJSONMessage(w, http.StatusServiceUnavailable, "This replica is on standby, the monitoring runs on the leader.")
+			return
+		}
+	}
+
+	var lookback int
+	if param := r.URL.Query().Get("lookback"); param != "" {
+		var err error
+		lookback, err = strconv.Atoi(param)
+		if err != nil || lookback < 1 {
+			writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Invalid lookback: %s", param))
+			return
+		}
+	}
+
+	var dryRun bool
+	if param := r.URL.Query().Get("dryRun"); param != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(param)
+		if err != nil {
+			writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Invalid dryRun: %s", param))
+			return
+		}
+	}
+
+	services, err := api.servicesFor(r.URL.Query().Get("contentType"))
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, finishRun, started := api.startRun(r.Context())
+	if !started {
+		writeJSONMessage(w, http.StatusServiceUnavailable, "The service is shutting down.")
+		return
+	}
+	defer finishRun()
+
+	reports := []cycleReport{}
+	for _, service := range services {
+		collector := &collectingSink{next: service.completionSink()}
+		if dryRun && !service.dryRun {
+			// nothing is sent to the configured sinks
+			collector.next = nil
+			service.dryRun = true
+		}
+		service.sink = collector
+		service.lookbackOverride = lookback
+
+		// the cycles that have already run are reported even if the next ones can't run
+		summary, err := service.RunCycle(ctx)
+		report := cycleReport{cycleSummary: summary, DryRun: service.dryRun, ClosedTransactions: []closedTransaction{}}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			report.Error = "The request has been cancelled while waiting for the running cycle."
+		} else if err != nil {
+			report.Error = err.Error()
+		}
+		for _, record := range collector.collected() {
+			report.ClosedTransactions = append(report.ClosedTransactions, closedTransaction{record.TransactionID, record.UUID, record.Result, record.Rule})
+		}
+		reports = append(reports, report)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"runs": reports})
+}
+
+type contentHistory struct {
+	UUID   string `json:"uuid"`
+	Window string `json:"window"`
+	// the event reader only knows the open transactions, so the closed ones are missing
+	ClosedTransactionsMissing bool                `json:"closed_transactions_missing,omitempty"`
+	Transactions              []transactionStatus `json:"transactions"`
+}
+
+// getContentHistory returns every transaction of the content within the window (e.g. window=24h),
+// in every monitored content type, unless one is given through the contentType parameter.
+func (api *monitoringAPI) getContentHistory(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+
+	window := api.historyWindow
+	if param := r.URL.Query().Get("window"); param != "" {
+		d, err := time.ParseDuration(param)
+		if err != nil || d < time.Minute {
+			writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Invalid window: %s", param))
+			return
+		}
+		window = d
+	}
+
+	services, err := api.servicesFor(r.URL.Query().Get("contentType"))
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	history := contentHistory{UUID: uuid, Window: window.String(), Transactions: []transactionStatus{}}
+	for _, service := range services {
+		statuses, err := service.GetContentHistory(r.Context(), uuid, window)
+		if errors.Is(err, errClosedTransactionsUnavailable) {
+			history.ClosedTransactionsMissing = true
+		} else if err != nil {
+			logger.NewEntry("").WithUUID(uuid).WithError(err).Error("Looking up the content history has failed.")
+			writeJSONMessage(w, http.StatusServiceUnavailable, "The event reader could not be queried.")
+			return
+		}
+		history.Transactions = append(history.Transactions, statuses...)
+	}
+	sort.SliceStable(history.Transactions, func(i, j int) bool {
+		return parseTime(history.Transactions[i].StartTime).Before(parseTime(history.Transactions[j].StartTime))
+	})
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// getTransaction looks the transaction up in every monitored content type, unless one is given through the contentType parameter.
+func (api *monitoringAPI) getTransaction(w http.ResponseWriter, r *http.Request) {
+	tid := r.PathValue("tid")
+
+	services, err := api.servicesFor(r.URL.Query().Get("contentType"))
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, service := range services {
+		status, err := service.GetTransactionStatus(r.Context(), tid)
+		if errors.Is(err, errTransactionNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.NewEntry(tid).WithError(err).Error("Looking up the transaction has failed.")
+			writeJSONMessage(w, http.StatusServiceUnavailable, "The event reader could not be queried.")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	writeJSONMessage(w, http.StatusNotFound, fmt.Sprintf("Transaction %s has not been found.", tid))
+}
+
+// servicesFor returns the services of the given content type, or all of them if it is empty.
+func (api *monitoringAPI) servicesFor(contentType string) ([]AnnotationsMonitoringService, error) {
+	if contentType == "" {
+		return api.services, nil
+	}
+
+	for _, service := range api.services {
+		if strings.EqualFold(service.contentType.name, contentType) {
+			return []AnnotationsMonitoringService{service}, nil
+		}
+	}
+	return nil, fmt.Errorf("content type %s is not monitored", contentType)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warnf(nil, "Writing the response has failed: %v", err)
+	}
+}
+
+func writeJSONMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMonitoringAPI(readerMock *eventReaderMock) *http.ServeMux {
+	var services []AnnotationsMonitoringService
+	for _, ct := range []string{annotationsContentType, suggestionsContentType} {
+		services = append(services, AnnotationsMonitoringService{
+			eventReader:               readerMock,
+			contentType:               contentTypeRegistry[ct],
+			maxLookbackPeriod:         60,
+			supersededCheckbackPeriod: 30,
+		})
+	}
+
+	serveMux := http.NewServeMux()
+	newMonitoringAPI(services, time.Hour, nil).register(serveMux)
+	return serveMux
+}
+
+func TestGetTransaction_Found(t *testing.T) {
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransaction", "annotations", "tid_1", "90m").Return(transactionEvent{}, errTransactionNotFound).
+		On("GetTransaction", "suggestions", "tid_1", "90m").Return(transactionEvent{
+		TransactionID: "tid_1",
+		UUID:          "uuid_1",
+		Events: []publishEvent{
+			{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+			{Event: mapperEvent, Time: "2017-09-22T11:50:05Z", IsValid: "true"},
+			{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:10Z", Level: infoLevel},
+		},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("GET", "/transactions/tid_1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var status transactionStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "tid_1", status.TransactionID)
+	assert.Equal(t, suggestionsContentType, status.ContentType)
+	assert.Equal(t, completedState, status.State)
+	assert.Equal(t, float64(10), status.Duration)
+	assert.Len(t, status.Events, 3)
+	readerMock.AssertExpectations(t)
+}
+
+func TestGetTransaction_ContentTypeFilter(t *testing.T) {
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransaction", "suggestions", "tid_1", "90m").Return(transactionEvent{}, errTransactionNotFound)
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("GET", "/transactions/tid_1?contentType=suggestions", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"message":"Transaction tid_1 has not been found."}`, w.Body.String())
+	readerMock.AssertNotCalled(t, "GetTransaction", "annotations", "tid_1", "90m")
+}
+
+func TestGetTransaction_UnknownContentType(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(new(eventReaderMock)).ServeHTTP(w, httptest.NewRequest("GET", "/transactions/tid_1?contentType=Images", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"content type Images is not monitored"}`, w.Body.String())
+}
+
+func TestGetTransaction_EventReaderError(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransaction", "annotations", "tid_1", "90m").Return(transactionEvent{}, errors.New("timeout"))
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("GET", "/transactions/tid_1", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestGetContentHistory(t *testing.T) {
+	start := time.Now().Add(-time.Hour).UTC()
+	at := func(minutes int) string {
+		return start.Add(time.Duration(minutes) * time.Minute).Format(defaultTimestampFormat)
+	}
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetAllTransactionsForUUIDs", "annotations", []string{"uuid_1"}, "120m").Return(transactions{
+		{TransactionID: "tid_3", UUID: "uuid_1", StartTime: at(20), Events: []publishEvent{
+			{Event: startEvent, Time: at(20), ContentType: annotationsContentType},
+		}},
+		{TransactionID: "tid_1", UUID: "uuid_1", StartTime: at(0), Events: []publishEvent{
+			{Event: startEvent, Time: at(0), ContentType: annotationsContentType},
+		}},
+		{TransactionID: "tid_2", UUID: "uuid_1", StartTime: at(10), Events: []publishEvent{
+			{Event: startEvent, Time: at(10), ContentType: annotationsContentType},
+			{Event: mapperEvent, Time: at(10), IsValid: "true", ContentType: annotationsContentType},
+			{Event: completenessCriteriaEvent, Time: at(11), Level: infoLevel, ContentType: annotationsContentType},
+		}},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("GET", "/content/uuid_1/history?window=2h&contentType=Annotations", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history contentHistory
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Equal(t, "uuid_1", history.UUID)
+	assert.Equal(t, "2h0m0s", history.Window)
+	assert.Len(t, history.Transactions, 3)
+
+	assert.Equal(t, "tid_1", history.Transactions[0].TransactionID)
+	assert.Equal(t, supersededState, history.Transactions[0].State)
+	assert.Equal(t, "tid_2", history.Transactions[0].SupersededBy)
+
+	assert.Equal(t, "tid_2", history.Transactions[1].TransactionID)
+	assert.Equal(t, completedState, history.Transactions[1].State)
+	assert.Empty(t, history.Transactions[1].SupersededBy)
+
+	assert.Equal(t, "tid_3", history.Transactions[2].TransactionID)
+	assert.Equal(t, openState, history.Transactions[2].State)
+	assert.Empty(t, history.Transactions[2].SupersededBy)
+}
+
+func TestGetContentHistory_ClosedSupersedingTransaction(t *testing.T) {
+	start := time.Now().Add(-time.Hour).UTC()
+	at := func(minutes int) string {
+		return start.Add(time.Duration(minutes) * time.Minute).Format(defaultTimestampFormat)
+	}
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetAllTransactionsForUUIDs", "annotations", []string{"uuid_1"}, "120m").Return(transactions{
+		{TransactionID: "tid_1", UUID: "uuid_1", StartTime: at(0), Events: []publishEvent{
+			{Event: startEvent, Time: at(0), ContentType: annotationsContentType},
+		}},
+		// the monitoring has closed the superseding transaction already
+		{TransactionID: "tid_2", UUID: "uuid_1", StartTime: at(10), Events: []publishEvent{
+			{Event: startEvent, Time: at(10), ContentType: annotationsContentType},
+			{Event: mapperEvent, Time: at(10), IsValid: "true", ContentType: annotationsContentType},
+			{Event: completenessCriteriaEvent, Time: at(11), Level: infoLevel, ContentType: annotationsContentType},
+			{Event: endEvent, Time: at(11), IsValid: "true", MonitoringEvent: "true", ContentType: annotationsContentType},
+		}},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("GET", "/content/uuid_1/history?window=2h&contentType=Annotations", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history contentHistory
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Len(t, history.Transactions, 2)
+
+	assert.Equal(t, "tid_1", history.Transactions[0].TransactionID)
+	assert.Equal(t, supersededState, history.Transactions[0].State)
+	assert.Equal(t, "tid_2", history.Transactions[0].SupersededBy)
+
+	assert.Equal(t, "tid_2", history.Transactions[1].TransactionID)
+	assert.Equal(t, completedState, history.Transactions[1].State)
+	assert.True(t, history.Transactions[1].Closed)
+	assert.Equal(t, at(11), history.Transactions[1].EndTime)
+}
+
+func TestGetContentHistory_OpenTransactionsOnly(t *testing.T) {
+	// the splunk-event-reader ignores the parameters it doesn't know, and only returns the open transactions
+	eventReaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"transaction_id":"tid_2","uuid":"uuid_1","events":[{"event":"PublishStart","content_type":"Annotations"}]}]`))
+	}))
+	defer eventReaderServer.Close()
+
+	service := AnnotationsMonitoringService{
+		eventReader: SplunkEventReader{eventReaderAddress: eventReaderServer.URL},
+		contentType: contentTypeRegistry[annotationsContentType],
+	}
+	serveMux := http.NewServeMux()
+	newMonitoringAPI([]AnnotationsMonitoringService{service}, time.Hour, nil).register(serveMux)
+
+	w := httptest.NewRecorder()
+	serveMux.ServeHTTP(w, httptest.NewRequest("GET", "/content/uuid_1/history", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the history doesn't pass for a complete one
+	var history contentHistory
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.True(t, history.ClosedTransactionsMissing)
+	assert.Len(t, history.Transactions, 1)
+}
+
+func TestGetContentHistory_DefaultWindow(t *testing.T) {
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetAllTransactionsForUUIDs", "annotations", []string{"uuid_1"}, "60m").Return(transactions{}, nil).
+		On("GetAllTransactionsForUUIDs", "suggestions", []string{"uuid_1"}, "60m").Return(transactions{}, nil)
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("GET", "/content/uuid_1/history", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"uuid":"uuid_1","window":"1h0m0s","transactions":[]}`, w.Body.String())
+	readerMock.AssertExpectations(t)
+}
+
+func TestGetContentHistory_InvalidWindow(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(new(eventReaderMock)).ServeHTTP(w, httptest.NewRequest("GET", "/content/uuid_1/history?window=yesterday", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"Invalid window: yesterday"}`, w.Body.String())
+}
+
+func TestRunCycle(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransactions", "annotations", "30m").Return(transactions{
+		{TransactionID: "tid_1", UUID: "uuid_1", Events: []publishEvent{
+			{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+			{Event: mapperEvent, Time: "2017-09-22T11:50:05Z", IsValid: "true"},
+			{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:10Z", Level: infoLevel},
+		}},
+		{TransactionID: "tid_2", UUID: "uuid_2", Events: []publishEvent{
+			{Event: startEvent, Time: time.Now().Format(defaultTimestampFormat)},
+		}},
+	}, nil).
+		On("GetTransactionsForUUIDs", "annotations", []string{"uuid_1"}, "60m").Return(transactions{}, nil)
+
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(readerMock).ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run?lookback=30&contentType=annotations", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"runs":[{
+		"content_type":"Annotations",
+		"lookback_period_min":30,
+		"transactions":2,
+		"closed":1,
+		"unprocessed":0,
+		"cancelled":false,
+		"failed":false,
+		"dry_run":false,
+		"closed_transactions":[{"transaction_id":"tid_1","uuid":"uuid_1","result":"completed","rule":"default"}]
+	}]}`, w.Body.String())
+	readerMock.AssertExpectations(t)
+	readerMock.AssertNotCalled(t, "GetLatestEvent", "annotations", "60m")
+}
+
+func TestRunCycle_WaitsForRunningCycle(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransactions", "annotations", "30m").Return(transactions{}, nil)
+
+	lock := newCycleLock()
+	serveMux := http.NewServeMux()
+	newMonitoringAPI([]AnnotationsMonitoringService{{
+		eventReader: readerMock,
+		contentType: contentTypeRegistry[annotationsContentType],
+		cycleLock:   lock,
+	}}, time.Hour, nil).register(serveMux)
+
+	// a scheduled cycle is running
+	assert.NoError(t, lock.lock(context.Background()))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		serveMux.ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run?lookback=30", nil))
+		done <- w
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("the cycles have overlapped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lock.unlock()
+	w := <-done
+	assert.Equal(t, http.StatusOK, w.Code)
+	readerMock.AssertNumberOfCalls(t, "GetTransactions", 1)
+}
+
+func TestRunCycle_CancelledWhileWaiting(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransactions", "annotations", "30m").Return(transactions{
+		{TransactionID: "tid_1", UUID: "uuid_1", Events: []publishEvent{
+			{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+			{Event: mapperEvent, Time: "2017-09-22T11:50:05Z", IsValid: "true"},
+			{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:10Z", Level: infoLevel},
+		}},
+	}, nil).
+		On("GetTransactionsForUUIDs", "annotations", []string{"uuid_1"}, "60m").Return(transactions{}, nil)
+
+	lock := newCycleLock()
+	serveMux := http.NewServeMux()
+	newMonitoringAPI([]AnnotationsMonitoringService{{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 30,
+		sink:                      &recordingSink{},
+	}, {
+		eventReader: readerMock,
+		contentType: contentTypeRegistry[suggestionsContentType],
+		cycleLock:   lock,
+	}}, time.Hour, nil).register(serveMux)
+
+	// a scheduled suggestions cycle is running
+	assert.NoError(t, lock.lock(context.Background()))
+	defer lock.unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	serveMux.ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run?lookback=30", nil).WithContext(ctx))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"runs":[{
+		"content_type":"Annotations",
+		"lookback_period_min":30,
+		"transactions":1,
+		"closed":1,
+		"unprocessed":0,
+		"cancelled":false,
+		"failed":false,
+		"dry_run":false,
+		"closed_transactions":[{"transaction_id":"tid_1","uuid":"uuid_1","result":"completed","rule":"default"}]
+	}, {
+		"content_type":"Suggestions",
+		"lookback_period_min":0,
+		"transactions":0,
+		"closed":0,
+		"unprocessed":0,
+		"cancelled":true,
+		"failed":false,
+		"dry_run":false,
+		"closed_transactions":[],
+		"error":"The request has been cancelled while waiting for the running cycle."
+	}]}`, w.Body.String())
+	readerMock.AssertExpectations(t)
+}
+
+func TestRunCycle_Stopped(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	reader := newBlockingEventReader()
+	serveMux := http.NewServeMux()
+	api := newMonitoringAPI([]AnnotationsMonitoringService{{
+		eventReader:       reader,
+		contentType:       contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod: 60,
+	}}, time.Hour, nil)
+	api.register(serveMux)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		serveMux.ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run", nil))
+		done <- w
+	}()
+	<-reader.called
+
+	// the running cycle is cancelled, and has finished once stop returns
+	stopped := make(chan struct{})
+	go func() {
+		api.stop()
+		close(stopped)
+	}()
+	w := <-done
+	<-stopped
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"cancelled":true`)
+
+	// no cycle is started afterwards
+	w = httptest.NewRecorder()
+	serveMux.ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"message":"The service is shutting down."}`, w.Body.String())
+}
+
+func TestRunCycle_Standby(t *testing.T) {
+	election, err := newLeaderElection(newMemoryLeaderElector(&memoryLease{}, "replica-1", time.Hour), time.Hour, time.Minute, time.Second)
+	assert.NoError(t, err)
+	election.setLeading(false, "replica-2")
+
+	serveMux := http.NewServeMux()
+	newMonitoringAPI(nil, time.Hour, election).register(serveMux)
+
+	w := httptest.NewRecorder()
+	serveMux.ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestRunCycle_InvalidLookback(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestMonitoringAPI(new(eventReaderMock)).ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run?lookback=-5", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"Invalid lookback: -5"}`, w.Body.String())
+}
+
+func TestRunCycle_DryRun(t *testing.T) {
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	readerMock := new(eventReaderMock)
+	readerMock.On("GetTransactions", "annotations", "30m").Return(transactions{
+		{TransactionID: "tid_1", UUID: "uuid_1", StartTime: "2017-09-22T11:40:00Z", Events: []publishEvent{
+			{Event: startEvent, Time: "2017-09-22T11:40:00Z", ContentType: annotationsContentType},
+		}},
+		{TransactionID: "tid_2", UUID: "uuid_1", StartTime: "2017-09-22T11:50:00Z", Events: []publishEvent{
+			{Event: startEvent, Time: "2017-09-22T11:50:00Z", ContentType: annotationsContentType},
+			{Event: mapperEvent, Time: "2017-09-22T11:50:05Z", IsValid: "true", ContentType: annotationsContentType},
+			{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:10Z", Level: infoLevel, ContentType: annotationsContentType},
+		}},
+	}, nil).
+		On("GetTransactionsForUUIDs", "annotations", []string{"uuid_1"}, "90m").Return(transactions{
+		{TransactionID: "tid_1", UUID: "uuid_1", StartTime: "2017-09-22T11:40:00Z", Events: []publishEvent{
+			{Event: startEvent, Time: "2017-09-22T11:40:00Z", ContentType: annotationsContentType},
+		}},
+	}, nil)
+
+	checkpoints, err := newFileCheckpointStore(t.TempDir())
+	assert.NoError(t, err)
+	sink := &recordingSink{}
+	closedTxs := newClosedTransactionIndex(time.Hour)
+
+	serveMux := http.NewServeMux()
+	newMonitoringAPI([]AnnotationsMonitoringService{{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		supersededCheckbackPeriod: 60,
+		checkpoints:               checkpoints,
+		closedTxs:                 closedTxs,
+		sink:                      sink,
+	}}, time.Hour, nil).register(serveMux)
+
+	w := httptest.NewRecorder()
+	serveMux.ServeHTTP(w, httptest.NewRequest("POST", "/__monitor/run?lookback=30&dryRun=true", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Runs []cycleReport `json:"runs"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Runs, 1)
+	assert.True(t, response.Runs[0].DryRun)
+	assert.Equal(t, []closedTransaction{
+		{TransactionID: "tid_2", UUID: "uuid_1", Result: completedResult, Rule: "default"},
+		{TransactionID: "tid_1", UUID: "uuid_1", Result: supersededResult, Rule: supersededRule},
+	}, response.Runs[0].ClosedTransactions)
+
+	// nothing has been closed
+	assert.Empty(t, sink.records)
+	assert.Empty(t, closedTxs.snapshot())
+	_, found, err := checkpoints.Load(annotationsContentType)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
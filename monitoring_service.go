@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/Financial-Times/go-logger"
@@ -11,97 +13,457 @@ import (
 
 const (
 	defaultTimestampFormat    = time.RFC3339Nano
-	contentType               = "Annotations"
 	startEvent                = "PublishStart"
 	completenessCriteriaEvent = "SaveNeo4j"
 	endEvent                  = "PublishEnd"
 	infoLevel                 = "info"
+	errorLevel                = "error"
+	timeoutOutcome            = "timeout"
+	failedOutcome             = "failed"
+
+	// rules that close a transaction, besides the completeness rule of its content type
+	validationRule    = "validation"
+	supersededRule    = "superseded"
+	timeoutRule       = "timeout"
+	pipelineErrorRule = "pipeline error"
 )
 
 type MonitoringService interface {
-	CloseCompletedTransactions()
-	CloseSupersededTransactions(completedTransactions completedTransactionEvents, refInterval int)
-	DetermineLookbackPeriod() int
+	CloseCompletedTransactions(ctx context.Context) cycleSummary
+	CloseSupersededTransactions(ctx context.Context, completedTransactions completedTransactionEvents, refInterval int) []string
+	CloseTimedOutTransactions(openTransactions transactions, excludedTids []string) []string
+	DetermineLookbackPeriod(ctx context.Context) int
 }
 
 type AnnotationsMonitoringService struct {
 	eventReader               EventReader
+	contentType               contentTypeConfig
 	maxLookbackPeriod         int
 	supersededCheckbackPeriod int
+	checkpoints               CheckpointStore
+	closedTxs                 *closedTransactionIndex
+	sink                      CompletionSink
+	// longer lookback periods are fetched and processed slice by slice, in chronological order; not sliced if zero
+	lookbackSlice time.Duration
+	// circuit breaker guarding the event reader calls, nil if there is none
+	eventReaderCircuit *circuitBreaker
+	// serializes the cycles of the content type, whether they are scheduled or run on demand
+	cycleLock cycleLock
+	// lookback period (in minutes) of a cycle run on demand; it is determined from the last checkpoint or event if zero
+	lookbackOverride int
+	// in dry-run, the closures are only reported to the sink: the transactions are neither remembered as closed,
+	// nor counted in the metrics, and no checkpoint is saved
+	dryRun bool
+	// when replaying a dump, the timeouts are evaluated at its latest event rather than now
+	replayedAt time.Time
 }
 
-func (s AnnotationsMonitoringService) CloseCompletedTransactions() {
+// cycleLock is a mutex that can be waited for until a context is done; a nil lock doesn't lock anything.
+type cycleLock chan struct{}
 
-	lookbackTime := s.DetermineLookbackPeriod()
+func newCycleLock() cycleLock {
+	return make(cycleLock, 1)
+}
+
+func (l cycleLock) lock(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l cycleLock) unlock() {
+	if l != nil {
+		<-l
+	}
+}
+
+// RunCycle closes the completed transactions once no other cycle of the content type is running.
+func (s AnnotationsMonitoringService) RunCycle(ctx context.Context) (cycleSummary, error) {
+	if err := s.cycleLock.lock(ctx); err != nil {
+		return cycleSummary{ContentType: s.contentType.name, Cancelled: true}, err
+	}
+	defer s.cycleLock.unlock()
+
+	return s.CloseCompletedTransactions(ctx), nil
+}
+
+// cycleSummary describes what a monitoring cycle has processed.
+type cycleSummary struct {
+	ContentType    string `json:"content_type"`
+	LookbackPeriod int    `json:"lookback_period_min"`
+	// open transactions returned by the event reader
+	Transactions int `json:"transactions"`
+	Closed       int `json:"closed"`
+	// transactions left unprocessed, because the cycle has been cancelled
+	Unprocessed int  `json:"unprocessed"`
+	Cancelled   bool `json:"cancelled"`
+	Failed      bool `json:"failed"`
+	// the cycle has failed without calling the event reader, because its circuit is open
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func (s AnnotationsMonitoringService) CloseCompletedTransactions(ctx context.Context) cycleSummary {
+
+	summary := cycleSummary{ContentType: s.contentType.name}
+
+	// while the event reader keeps failing, the cycles are skipped instead of adding to its load and to the logs
+	if s.eventReaderCircuit != nil && s.eventReaderCircuit.isOpen() {
+		s.skipCycle(&summary)
+		return summary
+	}
+
+	windowEnd := time.Now()
+	s.closedTxs.expire(windowEnd)
+
+	lookbackTime := s.DetermineLookbackPeriod(ctx)
+	summary.LookbackPeriod = lookbackTime
+
+	if lookback := time.Duration(lookbackTime) * time.Minute; s.lookbackSlice > 0 && lookback > s.lookbackSlice {
+		s.closeSlicedTransactions(ctx, windowEnd.Add(-lookback), windowEnd, &summary)
+	} else {
+		// retrieve all the open transactions for a particular content type
+		evaluated, err := s.retrieveTransactions(ctx, fmt.Sprintf("%dm", lookbackTime))
+		if err != nil {
+			s.readerFailure(ctx, "GetTransactions", err, &summary)
+			return summary
+		}
+
+		s.closeEvaluatedTransactions(ctx, evaluated, lookbackTime, &summary)
+	}
+
+	if s.dryRun {
+		return summary
+	}
+
+	// the window hasn't been fully processed, or it doesn't follow the last one: only the closed transactions are persisted
+	if summary.Cancelled || summary.Failed || s.lookbackOverride > 0 {
+		s.saveClosedTransactions()
+		return summary
+	}
 
-	// retrieve all the open transactions for a particular content type
-	txs, err := s.eventReader.GetTransactions(strings.ToLower(contentType), fmt.Sprintf("%dm", lookbackTime))
+	s.saveCheckpoint(windowEnd)
+	return summary
+}
+
+// closeSlicedTransactions processes the [from, to) window slice by slice, in chronological order; the end of every processed
+// slice is checkpointed, so that if a slice fails, the next cycle resumes from it instead of fetching the whole window again.
+func (s AnnotationsMonitoringService) closeSlicedTransactions(ctx context.Context, from, to time.Time, summary *cycleSummary) {
+	for start := from; start.Before(to); start = start.Add(s.lookbackSlice) {
+		end := start.Add(s.lookbackSlice)
+		if end.After(to) {
+			end = to
+		}
+
+		slice := s.CloseTransactionsBetween(ctx, start, end)
+		summary.Transactions += slice.Transactions
+		summary.Closed += slice.Closed
+		summary.Unprocessed += slice.Unprocessed
+		if slice.Cancelled || slice.Failed {
+			summary.Cancelled, summary.Failed = slice.Cancelled, slice.Failed
+			return
+		}
+
+		// the end of the window is checkpointed once the whole cycle has succeeded
+		if !s.dryRun && s.lookbackOverride == 0 && end.Before(to) {
+			s.saveCheckpoint(end)
+		}
+	}
+}
+
+// CloseTransactionsBetween closes the transactions that have started within the given window, applying the same rules as a monitoring cycle.
+func (s AnnotationsMonitoringService) CloseTransactionsBetween(ctx context.Context, from, to time.Time) cycleSummary {
+	summary := cycleSummary{ContentType: s.contentType.name}
+	s.closedTxs.expire(time.Now())
+
+	// the superseding transactions are looked for from the start of the window up to now
+	summary.LookbackPeriod = lookbackPeriodSince(from)
+
+	txs, err := s.eventReader.GetTransactionsBetween(ctx, s.contentType.readerContentType(), from, to)
 	if err != nil {
-		logger.Errorf(map[string]interface{}{}, err, "Monitoring transactions has failed.")
+		s.readerFailure(ctx, "GetTransactionsBetween", err, &summary)
+		return summary
+	}
+
+	s.closeTransactions(ctx, txs, summary.LookbackPeriod, &summary)
+	return summary
+}
+
+// retrieveTransactions evaluates the open transactions of the lookback period; if the event reader can stream them,
+// every transaction is evaluated as soon as it is decoded, so that only its evaluation is kept in memory.
+func (s AnnotationsMonitoringService) retrieveTransactions(ctx context.Context, lookbackPeriod string) ([]evaluatedTransaction, error) {
+	var evaluated []evaluatedTransaction
+
+	if streamer, ok := s.eventReader.(TransactionStreamer); ok {
+		err := streamer.StreamTransactions(ctx, s.contentType.readerContentType(), lookbackPeriod, func(tx transactionEvent) {
+			evaluated = append(evaluated, s.evaluate(tx))
+		})
+		return evaluated, err
+	}
+
+	txs, err := s.eventReader.GetTransactions(ctx, s.contentType.readerContentType(), lookbackPeriod)
+	for _, tx := range txs {
+		evaluated = append(evaluated, s.evaluate(tx))
+	}
+	return evaluated, err
+}
+
+// readerFailure records the failure of the event reader call that has retrieved the transactions of the cycle.
+func (s AnnotationsMonitoringService) readerFailure(ctx context.Context, operation string, err error, summary *cycleSummary) {
+	if ctx.Err() != nil {
+		logger.Infof(map[string]interface{}{
+			"content_type": s.contentType.name,
+		}, "Monitoring transactions has been cancelled.")
+		summary.Cancelled = true
 		return
 	}
 
+	if errors.Is(err, errCircuitOpen) {
+		s.skipCycle(summary)
+		return
+	}
+
+	recordEventReaderError(s.contentType.name, operation)
+	logger.Errorf(map[string]interface{}{
+		"content_type": s.contentType.name,
+	}, err, "Monitoring transactions has failed.")
+	summary.Failed = true
+}
+
+func (s AnnotationsMonitoringService) skipCycle(summary *cycleSummary) {
+	logger.Warnf(map[string]interface{}{
+		"content_type": s.contentType.name,
+	}, "Monitoring transactions has been skipped, the event reader circuit is open.")
+	summary.Failed = true
+	summary.Skipped = true
+}
+
+// closeTransactions closes the completed and failed transactions, then the ones they supersede and the timed out ones;
+// refInterval (in minutes) is how far back the transactions have been retrieved from.
+func (s AnnotationsMonitoringService) closeTransactions(ctx context.Context, txs transactions, refInterval int, summary *cycleSummary) {
+	evaluated := make([]evaluatedTransaction, 0, len(txs))
+	for _, tx := range txs {
+		evaluated = append(evaluated, s.evaluate(tx))
+	}
+	s.closeEvaluatedTransactions(ctx, evaluated, refInterval, summary)
+}
+
+// evaluatedTransaction holds what the closure of a transaction depends on, so that its events don't have to be kept.
+type evaluatedTransaction struct {
+	transactionID, uuid string
+	// the start time returned by the event reader, which the transactions are closed in the order of
+	txStartTime                 string
+	txStart                     time.Time
+	startTime, endTime, isValid string
+	// the name and time of the latest event, reported as the last stage of a timed out transaction
+	latest publishEvent
+	// the first pipeline error, if the transaction has failed
+	failure *publishEvent
+}
+
+func (s AnnotationsMonitoringService) evaluate(tx transactionEvent) evaluatedTransaction {
+	startTime, endTime, isValid := s.evaluateTransaction(tx)
+	latest := latestEvent(tx)
+
+	evaluated := evaluatedTransaction{
+		transactionID: tx.TransactionID,
+		uuid:          tx.UUID,
+		txStartTime:   tx.StartTime,
+		txStart:       tx.startedAt(),
+		startTime:     startTime,
+		endTime:       endTime,
+		isValid:       isValid,
+		latest:        publishEvent{Event: latest.Event, Time: latest.Time, at: latest.at},
+	}
+	if failure, failed := s.findFailure(tx); failed {
+		evaluated.failure = &failure
+	}
+	return evaluated
+}
+
+// openTransaction rebuilds the transaction from its start and latest events, which is what its timeout is computed from.
+func (e evaluatedTransaction) openTransaction(contentType contentTypeConfig) transactionEvent {
+	tx := transactionEvent{TransactionID: e.transactionID, UUID: e.uuid, StartTime: e.txStartTime}
+	if e.startTime != "" {
+		tx.Events = append(tx.Events, publishEvent{ContentType: contentType.name, Event: contentType.startEvent, Time: e.startTime})
+	}
+	if e.latest.Time != "" && (e.latest.Event != contentType.startEvent || e.latest.Time != e.startTime) {
+		tx.Events = append(tx.Events, e.latest)
+	}
+	return tx
+}
+
+func (s AnnotationsMonitoringService) closeEvaluatedTransactions(ctx context.Context, txs []evaluatedTransaction, refInterval int, summary *cycleSummary) {
+	suppressedBefore := s.closedTxs.suppressedDuplicates()
+	summary.Transactions = len(txs)
+
 	// transactions should be closed in the order they happened, so that the latest PublishEnd event indicates the actual status;
 	// in this way, if the app restarts, the unprocessed transactions would all be picked up again.
-	sort.Sort(txs)
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].txStart.Before(txs[j].txStart) })
 
 	var completedTxs completedTransactionEvents
+	var openTxs transactions
 
-	for _, tx := range txs {
-
-		var startTime, endTime, isValid string
-		for _, event := range tx.Events {
-			// find start or end event
-			if event.Event == startEvent {
-				startTime = event.Time
-			} else if event.Event == completenessCriteriaEvent && event.Level == infoLevel {
-				endTime = event.Time
-			}
+	for i, evaluated := range txs {
 
-			// find mapper event: if message is not valid, log it as a PublishEnd event;
-			// use isValid string to distinguish between missing and invalid events
-			if event.IsValid == "true" {
-				isValid = "true"
-			} else if event.IsValid == "false" {
-				isValid = "false"
-				endTime = event.Time
-			}
+		// on shutdown, stop between transactions: the remaining ones are picked up by the next run
+		if ctx.Err() != nil {
+			summary.Cancelled = true
+			summary.Unprocessed = len(txs) - i
+			break
 		}
 
-		// if it is not a completed and valid annotation transaction: ignore it
+		tx := transactionEvent{TransactionID: evaluated.transactionID, UUID: evaluated.uuid}
+		startTime, endTime, isValid := evaluated.startTime, evaluated.endTime, evaluated.isValid
+
+		// if it is not a completed and valid transaction: ignore it, unless it has failed or has been open for too long
 		if startTime == "" || endTime == "" || isValid == "" {
+			if evaluated.failure != nil && startTime != "" {
+				if s.closeFailedTransaction(tx, startTime, *evaluated.failure) {
+					summary.Closed++
+				}
+				continue
+			}
+			if startTime != "" && s.contentType.timeout > 0 {
+				openTxs = append(openTxs, evaluated.openTransaction(s.contentType))
+			}
 			continue
 		}
 
 		duration, err := computeDuration(startTime, endTime)
 		if err != nil {
 			logger.NewEntry(tx.TransactionID).WithUUID(tx.UUID).WithError(err).Error("Duration couldn't be determined, transaction won't be closed.")
+			s.recordSkippedTransaction()
 			continue
 		}
 
-		completedTxs = append(completedTxs, completedTransactionEvent{tx.TransactionID, tx.UUID, fmt.Sprint(duration.Seconds()), startTime, endTime})
+		// already closed transactions are still used for superseding, in case a previous superseded check has failed
+		completedTxs = append(completedTxs, completedTransactionEvent{
+			TransactionID: tx.TransactionID,
+			UUID:          tx.UUID,
+			Duration:      fmt.Sprint(duration.Seconds()),
+			StartTime:     startTime,
+			EndTime:       endTime,
+			start:         parseTime(startTime),
+		})
+
+		result, rule := completedResult, s.contentType.completeness.Name
+		if isValid != "true" {
+			result, rule = invalidResult, validationRule
+		}
+		closed := s.closeTransaction(completionRecord{
+			TransactionID: tx.TransactionID,
+			UUID:          tx.UUID,
+			StartTime:     startTime,
+			EndTime:       endTime,
+			Duration:      duration,
+			IsValid:       isValid,
+			Result:        result,
+			Rule:          rule,
+			Message:       "Transaction has finished",
+		})
+		if closed {
+			summary.Closed++
+		}
+	}
+
+	if !summary.Cancelled {
+		supersededTids := s.CloseSupersededTransactions(ctx, completedTxs, refInterval)
+		timedOutTids := s.CloseTimedOutTransactions(openTxs, supersededTids)
+		summary.Closed += len(supersededTids) + len(timedOutTids)
+	}
+
+	if suppressed := s.closedTxs.suppressedDuplicates() - suppressedBefore; suppressed > 0 {
+		if !s.dryRun {
+			suppressedDuplicatesTotal.WithLabelValues(s.contentType.name).Add(float64(suppressed))
+		}
 		logger.Infof(map[string]interface{}{
-			"@time":                endTime,
-			"logTime":              time.Now().Format(defaultTimestampFormat),
-			"event":                endEvent,
-			"transaction_id":       tx.TransactionID,
-			"uuid":                 tx.UUID,
-			"startTime":            startTime,
-			"endTime":              endTime,
-			"transaction_duration": fmt.Sprint(duration.Seconds()),
-			"monitoring_event":     "true",
-			"isValid":              isValid,
-			"content_type":         contentType,
-		}, "Transaction has finished")
+			"content_type":                s.contentType.name,
+			"suppressed_duplicates":       suppressed,
+			"total_suppressed_duplicates": s.closedTxs.suppressedDuplicates(),
+		}, "Transactions that had already been closed have been skipped.")
+	}
+}
+
+// evaluateTransaction finds the start and end time of the transaction, and whether its content is valid;
+// the end time and validity are empty as long as the transaction is not complete.
+func (s AnnotationsMonitoringService) evaluateTransaction(tx transactionEvent) (startTime, endTime, isValid string) {
+	var invalidTime string
+	for _, event := range tx.Events {
+		// find start event
+		if event.Event == s.contentType.startEvent {
+			startTime = event.Time
+		}
+
+		// find mapper event: if message is not valid, log it as a PublishEnd event;
+		// use isValid string to distinguish between missing and invalid events
+		if event.IsValid == "true" {
+			isValid = "true"
+		} else if event.IsValid == "false" {
+			isValid = "false"
+			invalidTime = event.Time
+		}
+	}
+
+	// find end event: invalid messages end at validation, valid ones when the completeness rule is satisfied
+	endTime = invalidTime
+	if isValid != "false" {
+		endTime, _ = s.contentType.completeness.match(tx.Events)
 	}
 
-	s.CloseSupersededTransactions(completedTxs, lookbackTime)
+	// content types that are not validated are considered valid once they have been written
+	if !s.contentType.validationRequired && isValid == "" {
+		isValid = "true"
+	}
+
+	return startTime, endTime, isValid
+}
+
+func (s AnnotationsMonitoringService) DetermineLookbackPeriod(ctx context.Context) int {
+	if s.lookbackOverride > 0 {
+		return s.lookbackOverride
+	}
+
+	lookbackPeriod := s.determineLookbackPeriod(ctx)
+
+	// stuck transactions don't log any more events: the window has to reach back to the timeout for them to be found
+	if timeoutPeriod := s.timeoutLookbackPeriod(); lookbackPeriod < timeoutPeriod {
+		lookbackPeriod = timeoutPeriod
+	}
+
+	lookbackPeriodMinutes.WithLabelValues(s.contentType.name).Set(float64(lookbackPeriod))
+	return lookbackPeriod
 }
 
-func (s AnnotationsMonitoringService) DetermineLookbackPeriod() int {
+// timeoutLookbackPeriod is the lookback period (in minutes) in which the transactions timing out since the previous cycle have started.
+func (s AnnotationsMonitoringService) timeoutLookbackPeriod() int {
+	if s.contentType.timeout <= 0 {
+		return 0
+	}
+	return int(math.Ceil((s.contentType.timeout + checkFrequency*time.Minute).Minutes()))
+}
+
+func (s AnnotationsMonitoringService) determineLookbackPeriod(ctx context.Context) int {
+
+	// resume from the last processed window; the event reader is only needed if there is no checkpoint
+	if cp, found := s.loadCheckpoint(); found {
+		lookbackPeriod := lookbackPeriodSince(cp.WindowEnd)
+		if lookbackPeriod > s.maxLookbackPeriod {
+			return s.maxLookbackPeriod
+		}
+		return lookbackPeriod
+	}
 
-	event, err := s.eventReader.GetLatestEvent(strings.ToLower(contentType), fmt.Sprintf("%dm", s.maxLookbackPeriod))
+	event, err := s.eventReader.GetLatestEvent(ctx, s.contentType.readerContentType(), fmt.Sprintf("%dm", s.maxLookbackPeriod))
 	if err != nil {
+		if !errors.Is(err, errCircuitOpen) {
+			recordEventReaderError(s.contentType.name, "GetLatestEvent")
+		}
 		return s.maxLookbackPeriod
 	}
 
@@ -110,6 +472,65 @@ func (s AnnotationsMonitoringService) DetermineLookbackPeriod() int {
 		return s.maxLookbackPeriod
 	}
 
+	return lookbackPeriodSince(t)
+}
+
+func (s AnnotationsMonitoringService) loadCheckpoint() (checkpoint, bool) {
+	if s.checkpoints == nil {
+		return checkpoint{}, false
+	}
+
+	cp, found, err := s.checkpoints.Load(s.contentType.name)
+	if err != nil {
+		logger.Errorf(map[string]interface{}{
+			"content_type": s.contentType.name,
+		}, err, "Loading the monitoring checkpoint has failed.")
+		return checkpoint{}, false
+	}
+	return cp, found
+}
+
+// saveCheckpoint records the end of the processed window and the transactions that have already been closed.
+func (s AnnotationsMonitoringService) saveCheckpoint(windowEnd time.Time) {
+	if s.checkpoints == nil {
+		return
+	}
+
+	cp := checkpoint{
+		WindowEnd:          windowEnd,
+		ClosedTransactions: s.closedTxs.snapshot(),
+	}
+
+	if err := s.checkpoints.Save(s.contentType.name, cp); err != nil {
+		logger.Errorf(map[string]interface{}{
+			"content_type": s.contentType.name,
+		}, err, "Saving the monitoring checkpoint has failed.")
+	}
+}
+
+// saveClosedTransactions persists the closed transactions, while keeping the end of the last fully processed window.
+func (s AnnotationsMonitoringService) saveClosedTransactions() {
+	if cp, found := s.loadCheckpoint(); found {
+		s.saveCheckpoint(cp.WindowEnd)
+	}
+}
+
+// RestoreClosedTransactions loads the closed transactions saved in the last checkpoint, so that they are not closed again after a restart.
+func (s AnnotationsMonitoringService) RestoreClosedTransactions() {
+	if cp, found := s.loadCheckpoint(); found {
+		s.closedTxs.restore(cp.ClosedTransactions)
+		s.closedTxs.expire(time.Now())
+	}
+}
+
+// closedTransactionsTTL is the time the closed transactions have to be remembered for: as long as the event reader can return them.
+func closedTransactionsTTL(maxLookbackPeriod, supersededCheckbackPeriod int) time.Duration {
+	return time.Duration(maxLookbackPeriod+supersededCheckbackPeriod) * time.Minute
+}
+
+// lookbackPeriodSince computes the lookback period (in minutes) needed to cover everything that happened since the given time.
+func lookbackPeriodSince(t time.Time) int {
+
 	// compute the time period since the last event was logged
 	// consider that value - 5 min => to keep it overlapping
 	period := time.Since(t)
@@ -121,7 +542,7 @@ func (s AnnotationsMonitoringService) DetermineLookbackPeriod() int {
 	return int(lookbackPeriod)
 }
 
-func (s AnnotationsMonitoringService) CloseSupersededTransactions(completedTransactions completedTransactionEvents, refInterval int) {
+func (s AnnotationsMonitoringService) CloseSupersededTransactions(ctx context.Context, completedTransactions completedTransactionEvents, refInterval int) []string {
 
 	// sort transactions
 	sort.Sort(completedTransactions)
@@ -133,64 +554,209 @@ func (s AnnotationsMonitoringService) CloseSupersededTransactions(completedTrans
 	}
 
 	if len(uuids) == 0 {
-		return
+		return nil
 	}
 
-	// get all the uncompleted transactions for those UUIDs, that have started before our actual set
-	unprocessedTxs, err := s.eventReader.GetTransactionsForUUIDs(strings.ToLower(contentType), uuids, fmt.Sprintf("%dm", refInterval+s.supersededCheckbackPeriod))
+	// index the uncompleted transactions of those UUIDs that could have been superseded by our actual set
+	candidates, retrieved, err := s.supersedableTransactions(ctx, uuids, fmt.Sprintf("%dm", refInterval+s.supersededCheckbackPeriod))
 	if err != nil {
-		logger.Errorf(nil, err, "Checking for superseded transactions has failed.")
-		return
+		recordEventReaderError(s.contentType.name, "GetTransactionsForUUIDs")
+		logger.Errorf(map[string]interface{}{
+			"content_type":           s.contentType.name,
+			"retrieved_transactions": retrieved,
+		}, err, "Checking for superseded transactions has failed.")
+		// the transactions that could be retrieved are still checked
+		if retrieved == 0 {
+			return nil
+		}
 	}
-	sort.Sort(unprocessedTxs)
+
+	var supersededTids []string
 
 	// take all the completed transactions
-	for _, ctx := range completedTransactions {
+	for _, completedTx := range completedTransactions {
 
-		processedTids := []string{}
+		// on shutdown, the superseded transactions of the remaining ones are closed by the next run
+		if ctx.Err() != nil {
+			break
+		}
 
-		// verify if within the unprocessed transactions there is any that have been superseded
-		for _, utx := range unprocessedTxs {
+		// verify if within the unprocessed transactions of the same UUID there is any that have been superseded
+		var unprocessed []supersedableTransaction
+		for _, utx := range candidates[completedTx.UUID] {
 
-			if utx.UUID == ctx.UUID {
+			// check that it is the same transaction: if so, ignore it
+			if utx.TransactionID == completedTx.TransactionID {
+				continue
+			}
 
-				// check that it is the same transaction: if so, ignore it
-				if utx.TransactionID == ctx.TransactionID {
-					processedTids = append(processedTids, utx.TransactionID)
-					continue
-				}
+			// check that it was a transaction that happened before the actual transaction
+			isEarlier, startTime := utx.earlierThan(completedTx)
+			if !isEarlier {
+				unprocessed = append(unprocessed, utx)
+				continue
+			}
 
-				// check that it was a transaction that happened before the actual transaction
-				if isEarlier, startTime := earlierTransaction(utx, ctx); isEarlier {
-
-					duration, err := computeDuration(startTime, ctx.EndTime)
-					if err != nil {
-						logger.NewEntry(utx.TransactionID).WithUUID(utx.UUID).WithError(err).Error("Duration couldn't be determined, transaction won't be closed.")
-						continue
-					}
-
-					processedTids = append(processedTids, utx.TransactionID)
-					logger.Infof(map[string]interface{}{
-						"@time":                ctx.EndTime,
-						"logTime":              time.Now().Format(defaultTimestampFormat),
-						"event":                endEvent,
-						"transaction_id":       utx.TransactionID,
-						"uuid":                 utx.UUID,
-						"startTime":            startTime,
-						"endTime":              ctx.EndTime,
-						"transaction_duration": fmt.Sprint(duration.Seconds()),
-						"monitoring_event":     "true",
-						// isValid field will be missing, because we can't tell for sure if that transaction was failing
-						// before it reached the mapper, or not. Also, we can't use the actual value for that transaction, because the article
-						// might have suffered validation changes by then.
-						"content_type": contentType,
-					}, fmt.Sprintf("Transaction has been superseded by tid=%s.", ctx.TransactionID))
-				}
+			duration, err := computeDuration(startTime, completedTx.EndTime)
+			if err != nil {
+				logger.NewEntry(utx.TransactionID).WithUUID(completedTx.UUID).WithError(err).Error("Duration couldn't be determined, transaction won't be closed.")
+				s.recordSkippedTransaction()
+				unprocessed = append(unprocessed, utx)
+				continue
+			}
+
+			closed := s.closeTransaction(completionRecord{
+				TransactionID: utx.TransactionID,
+				UUID:          completedTx.UUID,
+				StartTime:     startTime,
+				EndTime:       completedTx.EndTime,
+				Duration:      duration,
+				// isValid field will be missing, because we can't tell for sure if that transaction was failing
+				// before it reached the mapper, or not. Also, we can't use the actual value for that transaction, because the article
+				// might have suffered validation changes by then.
+				Result:       supersededResult,
+				Rule:         supersededRule,
+				SupersededBy: completedTx.TransactionID,
+				Message:      fmt.Sprintf("Transaction has been superseded by tid=%s.", completedTx.TransactionID),
+			})
+			if closed {
+				supersededTids = append(supersededTids, utx.TransactionID)
+			}
+		}
+
+		candidates[completedTx.UUID] = unprocessed
+	}
+
+	return supersededTids
+}
+
+// supersedableTransaction is what the superseded check needs to know of an uncompleted transaction.
+type supersedableTransaction struct {
+	TransactionID string
+	start         time.Time
+	// its start events, with their times only, in the order they have been logged
+	startEvents []publishEvent
+}
+
+// supersedableOf tells whether the transaction can be superseded: only the ones that have started,
+// with events of the monitored content type, can.
+func supersedableOf(tx transactionEvent, contentType contentTypeConfig) (supersedableTransaction, bool) {
+	isMonitoredEvent := false
+	utx := supersedableTransaction{TransactionID: tx.TransactionID, start: tx.startedAt()}
+	for _, event := range tx.Events {
+		// mark as an event of the monitored content type
+		if event.ContentType == contentType.name {
+			isMonitoredEvent = true
+		}
+		if event.Event == contentType.startEvent {
+			utx.startEvents = append(utx.startEvents, publishEvent{Time: event.Time, at: event.parsedTime()})
+		}
+	}
+	return utx, isMonitoredEvent && len(utx.startEvents) > 0
+}
+
+// earlierThan tells whether the transaction has started before the completed one, and when.
+func (utx supersedableTransaction) earlierThan(ctx completedTransactionEvent) (isEarlier bool, startTime string) {
+	completedStart := ctx.startedAt()
+	for _, event := range utx.startEvents {
+		if event.parsedTime().Before(completedStart) {
+			isEarlier = true
+			startTime = event.Time
+		}
+	}
+	return isEarlier, startTime
+}
+
+// supersedableTransactions indexes by UUID the transactions of the UUIDs that can be superseded, in the order they have started;
+// if the event reader can stream them, only what is indexed is kept in memory. It also returns how many transactions have been retrieved.
+func (s AnnotationsMonitoringService) supersedableTransactions(ctx context.Context, uuids []string, lookbackPeriod string) (map[string][]supersedableTransaction, int, error) {
+	candidates := map[string][]supersedableTransaction{}
+	retrieved := 0
+	index := func(tx transactionEvent) {
+		retrieved++
+		if utx, ok := supersedableOf(tx, s.contentType); ok {
+			candidates[tx.UUID] = append(candidates[tx.UUID], utx)
+		}
+	}
+
+	var err error
+	if streamer, ok := s.eventReader.(TransactionStreamer); ok {
+		err = streamer.StreamTransactionsForUUIDs(ctx, s.contentType.readerContentType(), uuids, lookbackPeriod, index)
+	} else {
+		var txs transactions
+		txs, err = s.eventReader.GetTransactionsForUUIDs(ctx, s.contentType.readerContentType(), uuids, lookbackPeriod)
+		for _, tx := range txs {
+			index(tx)
+		}
+	}
+
+	for _, utxs := range candidates {
+		sort.SliceStable(utxs, func(i, j int) bool { return utxs[i].start.Before(utxs[j].start) })
+	}
+	return candidates, retrieved, err
+}
+
+func (s AnnotationsMonitoringService) CloseTimedOutTransactions(openTransactions transactions, excludedTids []string) []string {
+
+	if s.contentType.timeout <= 0 {
+		return nil
+	}
+
+	var timedOutTids []string
+
+	// the transactions closed in the meantime (e.g. superseded ones) shouldn't be closed again
+	openTransactions = removeElements(openTransactions, excludedTids)
+
+	for _, tx := range openTransactions {
+
+		startTime := ""
+		for _, event := range tx.Events {
+			if event.Event == s.contentType.startEvent {
+				startTime = event.Time
 			}
 		}
 
-		unprocessedTxs = removeElements(unprocessedTxs, processedTids)
+		st, err := time.Parse(defaultTimestampFormat, startTime)
+		if err != nil {
+			logger.NewEntry(tx.TransactionID).WithUUID(tx.UUID).WithError(err).Error("Duration couldn't be determined, transaction won't be closed.")
+			s.recordSkippedTransaction()
+			continue
+		}
+
+		// transactions still within the timeout are in flight
+		now := s.timeoutsEvaluatedAt()
+		duration := now.Sub(st)
+		if duration < s.contentType.timeout {
+			continue
+		}
+
+		closed := s.closeTransaction(completionRecord{
+			TransactionID: tx.TransactionID,
+			UUID:          tx.UUID,
+			StartTime:     startTime,
+			EndTime:       now.Format(defaultTimestampFormat),
+			Duration:      duration,
+			IsValid:       timeoutOutcome,
+			Result:        timeoutResult,
+			Rule:          timeoutRule,
+			Outcome:       timeoutOutcome,
+			LastStage:     latestEvent(tx).Event,
+			Message:       fmt.Sprintf("Transaction has timed out after %v.", s.contentType.timeout),
+		})
+		if closed {
+			timedOutTids = append(timedOutTids, tx.TransactionID)
+		}
+	}
+
+	return timedOutTids
+}
+
+// timeoutsEvaluatedAt returns the time the transactions are timed out at: now, unless a dump is replayed.
+func (s AnnotationsMonitoringService) timeoutsEvaluatedAt() time.Time {
+	if !s.replayedAt.IsZero() {
+		return s.replayedAt
 	}
+	return time.Now()
 }
 
 func removeElements(events []transactionEvent, tids []string) []transactionEvent {
@@ -226,24 +792,103 @@ func uniqueAppend(uuids []string, uuid string) []string {
 	return append(uuids, uuid)
 }
 
-func earlierTransaction(utx transactionEvent, ctx completedTransactionEvent) (isEarlier bool, startTime string) {
+func earlierTransaction(utx transactionEvent, ctx completedTransactionEvent, contentType contentTypeConfig) (isEarlier bool, startTime string) {
+	candidate, ok := supersedableOf(utx, contentType)
+	if !ok {
+		return false, ""
+	}
+	return candidate.earlierThan(ctx)
+}
 
-	isAnnotationEvent := false
-	isEarlier = false
-	startTime = ""
-	for _, event := range utx.Events {
-		// mark as annotations event
-		if event.ContentType == contentType {
-			isAnnotationEvent = true
+// findFailure returns the first error logged by one of the known pipeline stages of the transaction.
+func (s AnnotationsMonitoringService) findFailure(tx transactionEvent) (publishEvent, bool) {
+	var failure publishEvent
+	found := false
+	for _, event := range tx.Events {
+		if event.Level == errorLevel && s.contentType.isPipelineStage(event.Event) && (!found || event.parsedTime().Before(failure.parsedTime())) {
+			failure = event
+			found = true
 		}
-		// find start event
-		if event.Event == startEvent && event.Time < ctx.StartTime {
-			isEarlier = true
-			startTime = event.Time
+	}
+	return failure, found
+}
+
+// recordSkippedTransaction counts a transaction that couldn't be closed, unless in dry-run.
+func (s AnnotationsMonitoringService) recordSkippedTransaction() {
+	if !s.dryRun {
+		recordSkippedTransaction(s.contentType.name)
+	}
+}
+
+func (s AnnotationsMonitoringService) closeFailedTransaction(tx transactionEvent, startTime string, failure publishEvent) bool {
+
+	duration, err := computeDuration(startTime, failure.Time)
+	if err != nil {
+		logger.NewEntry(tx.TransactionID).WithUUID(tx.UUID).WithError(err).Error("Duration couldn't be determined, transaction won't be closed.")
+		s.recordSkippedTransaction()
+		return false
+	}
+
+	return s.closeTransaction(completionRecord{
+		TransactionID: tx.TransactionID,
+		UUID:          tx.UUID,
+		StartTime:     startTime,
+		EndTime:       failure.Time,
+		Duration:      duration,
+		IsValid:       failedOutcome,
+		Result:        failedResult,
+		Rule:          pipelineErrorRule,
+		Outcome:       failedOutcome,
+		LastStage:     failure.Event,
+		FailedService: failure.ServiceName,
+		ErrorMessage:  failure.Msg,
+		Message:       fmt.Sprintf("Transaction has failed in %s.", failure.Event),
+	})
+}
+
+// closeTransaction emits the PublishEnd event of the transaction, unless it has already been closed.
+func (s AnnotationsMonitoringService) closeTransaction(record completionRecord) bool {
+	if s.closedTxs.isDuplicate(record.TransactionID) {
+		return false
+	}
+
+	now := time.Now()
+	record.ContentType = s.contentType.name
+	record.LogTime = now.Format(defaultTimestampFormat)
+
+	if err := s.completionSink().Send(record); err != nil {
+		logger.NewEntry(record.TransactionID).WithUUID(record.UUID).WithError(err).Error("Sending the transaction completion has failed.")
+		// the transaction is closed again by the next cycle, unless some of the sinks have received it
+		var partial partialSendError
+		if !errors.As(err, &partial) {
+			return false
 		}
 	}
 
-	return isAnnotationEvent && isEarlier, startTime
+	if !s.dryRun {
+		s.closedTxs.markClosed(record.TransactionID, now)
+		recordClosedTransaction(record.ContentType, record.Result, record.IsValid, record.Duration)
+	}
+	return true
+}
+
+// completionSink returns the configured sink; the completions are logged if there is none.
+func (s AnnotationsMonitoringService) completionSink() CompletionSink {
+	if s.sink == nil {
+		return loggerSink{}
+	}
+	return s.sink
+}
+
+// latestEvent returns the last event that has been logged for the transaction.
+func latestEvent(tx transactionEvent) publishEvent {
+	var latest publishEvent
+	for _, event := range tx.Events {
+		if later(event, latest) {
+			latest = event
+		}
+	}
+	return latest
 }
 
 func computeDuration(startTime, endTime string) (time.Duration, error) {
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -9,6 +12,7 @@ import (
 	"github.com/Financial-Times/go-logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_CloseCompletedTransactions_Success(t *testing.T) {
@@ -18,6 +22,7 @@ func Test_CloseCompletedTransactions_Success(t *testing.T) {
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
@@ -26,19 +31,19 @@ func Test_CloseCompletedTransactions_Success(t *testing.T) {
 			TransactionID: "tid1",
 			UUID:          "uuid1",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
-				{ContentType: contentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
 			}}}
 
-	readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
-		On("GetTransactions", strings.ToLower(contentType), "1445m").
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
 		Return(txs, nil).
-		On("GetTransactionsForUUIDs", strings.ToLower(contentType), []string{"uuid1"}, "1505m").
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "1505m").
 		Return(transactions{}, nil)
 
-	am.CloseCompletedTransactions()
+	am.CloseCompletedTransactions(context.Background())
 
 	// Verifications - check that the mock object was called with the previously specified parameters
 	readerMock.AssertExpectations(t)
@@ -48,7 +53,7 @@ func Test_CloseCompletedTransactions_Success(t *testing.T) {
 	assert.Equal(t, "Transaction has finished", hook.LastEntry().Message)
 	assert.Equal(t, endEvent, hook.LastEntry().Data["event"])
 	assert.Equal(t, "uuid1", hook.LastEntry().Data["uuid"])
-	assert.Equal(t, contentType, hook.LastEntry().Data["content_type"])
+	assert.Equal(t, annotationsContentType, hook.LastEntry().Data["content_type"])
 	assert.Equal(t, "true", hook.LastEntry().Data["monitoring_event"])
 	assert.Equal(t, "tid1", hook.LastEntry().Data["transaction_id"])
 
@@ -60,33 +65,153 @@ func Test_CloseCompletedTransactions_Success(t *testing.T) {
 	assert.True(t, hook.LastEntry().Data["@time"] != nil)
 }
 
+func Test_CloseCompletedTransactions_NotValidatedContentType(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[conceptsContentType],
+		supersededCheckbackPeriod: 60,
+	}
+
+	txs := transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events: []publishEvent{
+				{ContentType: conceptsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: conceptsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
+			}}}
+
+	readerMock.On("GetLatestEvent", "concepts", mock.AnythingOfType("string")).
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("GetTransactions", "concepts", "1445m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", "concepts", []string{"uuid1"}, "1505m").
+		Return(transactions{}, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	assert.Equal(t, "Transaction has finished", hook.LastEntry().Message)
+	assert.Equal(t, conceptsContentType, hook.LastEntry().Data["content_type"])
+	assert.Equal(t, "tid1", hook.LastEntry().Data["transaction_id"])
+	assert.Equal(t, "true", hook.LastEntry().Data["isValid"])
+	assert.Equal(t, "6", hook.LastEntry().Data["transaction_duration"])
+}
+
+func Test_CloseCompletedTransactions_CustomCompletenessRule(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.completeness = completenessRule{
+		Name:  "multiple stores",
+		AllOf: []eventMatcher{{Event: "SaveNeo4j", Level: "info"}, {Event: "SavePostgres", Level: "info"}},
+	}
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		supersededCheckbackPeriod: 60,
+	}
+
+	txs := transactions{
+		// written only to one of the stores: still open
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: "SaveNeo4j", Level: "info"},
+			}},
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid2",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:55.23038034Z", Event: "SavePostgres", Level: "info"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: "SaveNeo4j", Level: "info"},
+			}}}
+
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid2"}, "1505m").
+		Return(transactions{}, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	assert.Equal(t, 1, len(hook.AllEntries()))
+	assert.Equal(t, "Transaction has finished", hook.LastEntry().Message)
+	assert.Equal(t, "tid2", hook.LastEntry().Data["transaction_id"])
+	assert.Equal(t, "2017-09-22T11:45:55.23038034Z", hook.LastEntry().Data["endTime"])
+	assert.Equal(t, "8", hook.LastEntry().Data["transaction_duration"])
+}
+
 func Test_CloseCompletedTransactions_Timeout(t *testing.T) {
 
 	hook := logger.NewTestHook("annotations-monitoring-service")
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
-	readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
-		On("GetTransactions", strings.ToLower(contentType), "1445m").
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
 		Return(transactions{}, errors.New("timeout"))
 
-	am.CloseCompletedTransactions()
+	am.CloseCompletedTransactions(context.Background())
 
 	readerMock.AssertExpectations(t)
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
 	assert.Equal(t, "Monitoring transactions has failed.", hook.LastEntry().Message)
 }
 
+func Test_CloseCompletedTransactions_Cancelled(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 60,
+	}
+
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{}, context.Canceled).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "60m").
+		Return(transactions{}, context.Canceled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	am.CloseCompletedTransactions(ctx)
+
+	readerMock.AssertExpectations(t)
+	assert.Equal(t, "info", hook.LastEntry().Level.String())
+	assert.Equal(t, "Monitoring transactions has been cancelled.", hook.LastEntry().Message)
+}
+
 func Test_CloseCompletedTransactions_WrongTimeFormat(t *testing.T) {
 
 	hook := logger.NewTestHook("annotations-monitoring-service")
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
@@ -95,16 +220,16 @@ func Test_CloseCompletedTransactions_WrongTimeFormat(t *testing.T) {
 			TransactionID: "tid1",
 			UUID:          "uuid1",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
-				{ContentType: contentType, Time: "2017-09-22 11:45:00", Event: completenessCriteriaEvent, Level: "info"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22 11:45:00", Event: completenessCriteriaEvent, Level: "info"},
 			}}}
-	readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
-		On("GetTransactions", strings.ToLower(contentType), "1445m").
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
 		Return(txs, nil)
 
-	am.CloseCompletedTransactions()
+	am.CloseCompletedTransactions(context.Background())
 
 	readerMock.AssertExpectations(t)
 	assert.Equal(t, "error", hook.LastEntry().Level.String())
@@ -117,6 +242,7 @@ func Test_CloseCompletedTransactions_NotAnnotationsMessage(t *testing.T) {
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
@@ -128,12 +254,12 @@ func Test_CloseCompletedTransactions_NotAnnotationsMessage(t *testing.T) {
 				{ContentType: "", Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
 			}}}
 
-	readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
-		On("GetTransactions", strings.ToLower(contentType), "1445m").
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
 		Return(txs, nil)
 
-	am.CloseCompletedTransactions()
+	am.CloseCompletedTransactions(context.Background())
 
 	readerMock.AssertExpectations(t)
 	assert.Equal(t, 0, len(hook.Entries))
@@ -146,6 +272,7 @@ func Test_CloseCompletedTransactions_Invalid_Message(t *testing.T) {
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
@@ -154,18 +281,18 @@ func Test_CloseCompletedTransactions_Invalid_Message(t *testing.T) {
 			TransactionID: "tid1",
 			UUID:          "uuid1",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "false", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "false", Event: "Map"},
 			}}}
 
-	readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
-		On("GetTransactions", strings.ToLower(contentType), "1445m").
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
 		Return(txs, nil).
-		On("GetTransactionsForUUIDs", strings.ToLower(contentType), []string{"uuid1"}, "1505m").
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "1505m").
 		Return(transactions{}, nil)
 
-	am.CloseCompletedTransactions()
+	am.CloseCompletedTransactions(context.Background())
 
 	readerMock.AssertExpectations(t)
 	// Verify that all the log message fields are as expected...
@@ -173,7 +300,7 @@ func Test_CloseCompletedTransactions_Invalid_Message(t *testing.T) {
 	assert.Equal(t, "Transaction has finished", hook.LastEntry().Message)
 	assert.Equal(t, endEvent, hook.LastEntry().Data["event"])
 	assert.Equal(t, "uuid1", hook.LastEntry().Data["uuid"])
-	assert.Equal(t, contentType, hook.LastEntry().Data["content_type"])
+	assert.Equal(t, annotationsContentType, hook.LastEntry().Data["content_type"])
 	assert.Equal(t, "true", hook.LastEntry().Data["monitoring_event"])
 	assert.Equal(t, "tid1", hook.LastEntry().Data["transaction_id"])
 	assert.Equal(t, "2017-09-22T11:45:47.23038034Z", hook.LastEntry().Data["startTime"])
@@ -190,6 +317,7 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
@@ -200,8 +328,8 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 			UUID:          "uuid1",
 			StartTime:     "2017-09-22T11:45:00.00000000Z",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:45:00.00000000Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
 			}},
 		// incomplete - arbitrary order
 		transactionEvent{
@@ -209,16 +337,16 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 			UUID:          "uuid1",
 			StartTime:     "2017-09-22T11:50:00.00000000Z",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:50:00.00000000Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:50:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
 			}},
 		transactionEvent{
 			TransactionID: "tid2",
 			UUID:          "uuid1",
 			StartTime:     "2017-09-22T11:47:00.00000000Z",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:47:00.00000000Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:47:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
 			}},
 		// successful one
 		transactionEvent{
@@ -226,9 +354,9 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 			UUID:          "uuid1",
 			StartTime:     "2017-09-22T11:55:00.00000000Z",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
-				{ContentType: contentType, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
 			}},
 		// later successful one
 		transactionEvent{
@@ -236,20 +364,20 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 			UUID:          "uuid1",
 			StartTime:     "2017-09-22T11:56:00.00000000Z",
 			Events: []publishEvent{
-				{ContentType: contentType, Time: "2017-09-22T11:56:00.00000000Z", Event: startEvent},
-				{ContentType: contentType, Time: "2017-09-22T11:56:02.00000000Z", IsValid: "true", Event: "Map"},
-				{ContentType: contentType, Time: "2017-09-22T11:56:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:56:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:56:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:56:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
 			}},
 	}
 
-	readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
-		On("GetTransactions", strings.ToLower(contentType), "1445m").
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
 		Return(txs, nil).
-		On("GetTransactionsForUUIDs", strings.ToLower(contentType), mock.Anything, "1505m").
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), mock.Anything, "1505m").
 		Return(txs, nil)
 
-	am.CloseCompletedTransactions()
+	am.CloseCompletedTransactions(context.Background())
 
 	// Verifications - check that the mock object was called with the previously specified parameters
 	readerMock.AssertExpectations(t)
@@ -261,7 +389,7 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 	assert.Equal(t, "Transaction has been superseded by tid=tid4.", hook.LastEntry().Message)
 	assert.Equal(t, endEvent, hook.LastEntry().Data["event"])
 	assert.Equal(t, "uuid1", hook.LastEntry().Data["uuid"])
-	assert.Equal(t, contentType, hook.LastEntry().Data["content_type"])
+	assert.Equal(t, annotationsContentType, hook.LastEntry().Data["content_type"])
 	assert.Equal(t, "true", hook.LastEntry().Data["monitoring_event"])
 	assert.Equal(t, "tid3", hook.LastEntry().Data["transaction_id"])
 
@@ -273,6 +401,307 @@ func Test_CloseCompletedTransactions_ComplexScenario(t *testing.T) {
 	assert.True(t, hook.LastEntry().Data["@time"] != nil)
 }
 
+func Test_CloseCompletedTransactions_TimedOut(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.timeout = 30 * time.Minute
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		supersededCheckbackPeriod: 60,
+	}
+
+	txs := transactions{
+		// stuck after the mapper, superseded by tid3: shouldn't time out
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:45:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:02.00000000Z", IsValid: "true", Event: "Map"},
+			}},
+		// stuck after the mapper: time out
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid2",
+			StartTime:     "2017-09-22T11:46:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:46:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:46:00.00000000Z", Event: startEvent},
+			}},
+		// completed
+		transactionEvent{
+			TransactionID: "tid3",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:55:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+		// still in flight
+		transactionEvent{
+			TransactionID: "tid4",
+			UUID:          "uuid4",
+			StartTime:     time.Now().Add(-time.Minute).Format(defaultTimestampFormat),
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: time.Now().Add(-time.Minute).Format(defaultTimestampFormat), Event: startEvent},
+			}},
+	}
+
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "1505m").
+		Return(txs, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	entries := hook.AllEntries()
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "Transaction has finished", entries[0].Message)
+	assert.Equal(t, "tid3", entries[0].Data["transaction_id"])
+	assert.Equal(t, "Transaction has been superseded by tid=tid3.", entries[1].Message)
+	assert.Equal(t, "tid1", entries[1].Data["transaction_id"])
+
+	assert.Equal(t, "info", hook.LastEntry().Level.String())
+	assert.Equal(t, "Transaction has timed out after 30m0s.", hook.LastEntry().Message)
+	assert.Equal(t, endEvent, hook.LastEntry().Data["event"])
+	assert.Equal(t, "tid2", hook.LastEntry().Data["transaction_id"])
+	assert.Equal(t, "uuid2", hook.LastEntry().Data["uuid"])
+	assert.Equal(t, annotationsContentType, hook.LastEntry().Data["content_type"])
+	assert.Equal(t, "true", hook.LastEntry().Data["monitoring_event"])
+	assert.Equal(t, "2017-09-22T11:46:00.00000000Z", hook.LastEntry().Data["startTime"])
+	assert.Equal(t, timeoutOutcome, hook.LastEntry().Data["isValid"])
+	assert.Equal(t, timeoutOutcome, hook.LastEntry().Data["outcome"])
+	assert.Equal(t, "Map", hook.LastEntry().Data["lastStage"])
+	assert.Equal(t, hook.LastEntry().Data["@time"], hook.LastEntry().Data["endTime"])
+}
+
+func Test_CloseCompletedTransactions_TimedOutBeforeTheWindow(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newFileCheckpointStore(dir)
+	assert.NoError(t, err)
+	// the previous cycle has just run: the resume window is the 10 minutes minimum
+	assert.NoError(t, store.Save(annotationsContentType, checkpoint{WindowEnd: time.Now().Add(-time.Minute)}))
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.timeout = 30 * time.Minute
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		maxLookbackPeriod:         4320,
+		supersededCheckbackPeriod: 60,
+		checkpoints:               store,
+		closedTxs:                 newClosedTransactionIndex(time.Hour),
+	}
+
+	// stuck after the mapper for longer than the timeout, with no event since
+	start := time.Now().Add(-32 * time.Minute).Format(defaultTimestampFormat)
+	txs := transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			StartTime:     start,
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: start, Event: startEvent},
+				{ContentType: annotationsContentType, Time: start, IsValid: "true", Event: "Map"},
+			}},
+	}
+
+	// the window reaches back to the timeout, plus the time between two cycles
+	readerMock.On("GetTransactions", strings.ToLower(annotationsContentType), "35m").
+		Return(txs, nil)
+
+	summary := am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+	assert.Equal(t, 35, summary.LookbackPeriod)
+	assert.Equal(t, 1, summary.Closed)
+	assert.Equal(t, "Transaction has timed out after 30m0s.", hook.LastEntry().Message)
+	assert.Equal(t, "tid1", hook.LastEntry().Data["transaction_id"])
+}
+
+func Test_CloseCompletedTransactions_Streamed(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.timeout = 30 * time.Minute
+
+	var readerMock = new(streamingReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		supersededCheckbackPeriod: 60,
+	}
+
+	txs := transactions{
+		// completed, streamed before the transaction it supersedes
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:55:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+		// stuck after the mapper: time out
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid2",
+			StartTime:     "2017-09-22T11:46:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:46:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:46:02.00000000Z", IsValid: "true", Event: "Map"},
+			}},
+	}
+
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("StreamTransactions", strings.ToLower(annotationsContentType), "1445m").
+		Return(txs, nil).
+		On("StreamTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "1505m").
+		Return(transactions{}, nil)
+
+	summary := am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+	readerMock.AssertNotCalled(t, "GetTransactions", mock.Anything, mock.Anything)
+	readerMock.AssertNotCalled(t, "GetTransactionsForUUIDs", mock.Anything, mock.Anything, mock.Anything)
+
+	assert.Equal(t, 2, summary.Transactions)
+	assert.Equal(t, 2, summary.Closed)
+
+	entries := hook.AllEntries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "Transaction has finished", entries[0].Message)
+	assert.Equal(t, "tid2", entries[0].Data["transaction_id"])
+	assert.Equal(t, "Transaction has timed out after 30m0s.", entries[1].Message)
+	assert.Equal(t, "tid1", entries[1].Data["transaction_id"])
+	assert.Equal(t, "2017-09-22T11:46:00.00000000Z", entries[1].Data["startTime"])
+	assert.Equal(t, "Map", entries[1].Data["lastStage"])
+}
+
+func Test_CloseCompletedTransactions_Failed(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	ct := contentTypeRegistry[annotationsContentType]
+	ct.timeout = 30 * time.Minute
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               ct,
+		supersededCheckbackPeriod: 60,
+	}
+
+	txs := transactions{
+		// the writer has failed
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "error", ServiceName: "annotations-rw-neo4j", Msg: "Neo4j is unavailable"},
+			}},
+		// error logged by an unknown stage: not a failure, but open for too long
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid2",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:48.23038034Z", Event: "Notify", Level: "error"},
+			}},
+	}
+
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
+		Return(txs, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	entries := hook.AllEntries()
+	assert.Equal(t, 2, len(entries))
+
+	assert.Equal(t, "info", entries[0].Level.String())
+	assert.Equal(t, "Transaction has failed in SaveNeo4j.", entries[0].Message)
+	assert.Equal(t, endEvent, entries[0].Data["event"])
+	assert.Equal(t, "tid1", entries[0].Data["transaction_id"])
+	assert.Equal(t, "uuid1", entries[0].Data["uuid"])
+	assert.Equal(t, annotationsContentType, entries[0].Data["content_type"])
+	assert.Equal(t, "2017-09-22T11:45:47.23038034Z", entries[0].Data["startTime"])
+	assert.Equal(t, "2017-09-22T11:45:53.23038034Z", entries[0].Data["endTime"])
+	assert.Equal(t, "2017-09-22T11:45:53.23038034Z", entries[0].Data["@time"])
+	assert.Equal(t, "6", entries[0].Data["transaction_duration"])
+	assert.Equal(t, failedOutcome, entries[0].Data["outcome"])
+	assert.Equal(t, failedOutcome, entries[0].Data["isValid"])
+	assert.Equal(t, "annotations-rw-neo4j", entries[0].Data["failedService"])
+	assert.Equal(t, "Neo4j is unavailable", entries[0].Data["errorMessage"])
+
+	assert.Equal(t, "tid2", entries[1].Data["transaction_id"])
+	assert.Equal(t, timeoutOutcome, entries[1].Data["outcome"])
+}
+
+func Test_findFailure(t *testing.T) {
+	am := AnnotationsMonitoringService{
+		contentType: contentTypeRegistry[annotationsContentType],
+	}
+
+	_, failed := am.findFailure(transactionEvent{Events: []publishEvent{
+		{Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent, Level: "error"},
+		{Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
+	}})
+	assert.False(t, failed)
+
+	failure, failed := am.findFailure(transactionEvent{Events: []publishEvent{
+		{Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "error"},
+		{Time: "2017-09-22T11:45:49.23038034Z", Event: "Map", Level: "error"},
+	}})
+	assert.True(t, failed)
+	assert.Equal(t, "Map", failure.Event)
+}
+
+func Test_CloseTimedOutTransactions_Disabled(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	am := AnnotationsMonitoringService{
+		contentType: contentTypeRegistry[annotationsContentType],
+	}
+
+	am.CloseTimedOutTransactions(transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:00.00000000Z", Event: startEvent}},
+		}}, nil)
+
+	assert.Equal(t, 0, len(hook.Entries))
+}
+
 func Test_CloseSupersededTransactions(t *testing.T) {
 
 	hook := logger.NewTestHook("annotations-monitoring-service")
@@ -301,7 +730,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 			[]completedTransactionEvent{
 				{TransactionID: "tid1", UUID: "uuid1"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1"}, "120m",
 			transactions{}, nil,
 			"none", "",
 		},
@@ -311,7 +740,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			transactions{}, nil,
 			"none", "",
 		},
@@ -321,7 +750,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid1", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1"}, "120m",
 			transactions{}, nil,
 			"none", "",
 		},
@@ -331,7 +760,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			nil, errors.New("timed out"),
 			"error", "Checking for superseded transactions has failed.",
 		},
@@ -341,12 +770,12 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			transactions{
 				transactionEvent{
 					TransactionID: "tid3",
 					UUID:          "uuid3",
-					Events:        []publishEvent{{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
+					Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
 			}, nil,
 			"none", "",
 		},
@@ -356,12 +785,12 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			transactions{
 				transactionEvent{
 					TransactionID: "tid1",
 					UUID:          "uuid1",
-					Events:        []publishEvent{{ContentType: contentType, Time: "2017-09-22T12:00:00.23038034Z", Event: startEvent}}},
+					Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T12:00:00.23038034Z", Event: startEvent}}},
 			}, nil,
 			"none", "",
 		},
@@ -371,7 +800,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			transactions{
 				transactionEvent{
 					TransactionID: "tid1_2",
@@ -386,12 +815,12 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			transactions{
 				transactionEvent{
 					TransactionID: "tid1_2",
 					UUID:          "uuid1",
-					Events:        []publishEvent{{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
+					Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
 			}, nil,
 			"error", "Duration couldn't be determined, transaction won't be closed.",
 		},
@@ -401,15 +830,30 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z", EndTime: "2017-09-22T12:31:49.23038034Z"},
 				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z", EndTime: "2017-09-22T12:00:49.23038034Z"},
 			}, 60, 60,
-			strings.ToLower(contentType), []string{"uuid1", "uuid2"}, "120m",
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
 			transactions{
 				transactionEvent{
 					TransactionID: "tid1_2",
 					UUID:          "uuid1",
-					Events:        []publishEvent{{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
+					Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
 			}, nil,
 			"info", "Transaction has been superseded by tid=tid1.",
 		},
+		{
+			"Some UUID batches have failed: the retrieved transactions are still closed",
+			[]completedTransactionEvent{
+				{TransactionID: "tid2", UUID: "uuid2", StartTime: "2017-09-22T12:31:47.23038034Z", EndTime: "2017-09-22T12:31:49.23038034Z"},
+				{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z", EndTime: "2017-09-22T12:00:49.23038034Z"},
+			}, 60, 60,
+			strings.ToLower(annotationsContentType), []string{"uuid1", "uuid2"}, "120m",
+			transactions{
+				transactionEvent{
+					TransactionID: "tid1_2",
+					UUID:          "uuid1",
+					Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
+			}, errors.New("Failed to retrieve transactions"),
+			"info", "Transaction has been superseded by tid=tid1.",
+		},
 	}
 
 	for _, test := range tests {
@@ -419,6 +863,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 		var readerMock = new(eventReaderMock)
 		am := AnnotationsMonitoringService{
 			eventReader:               readerMock,
+			contentType:               contentTypeRegistry[annotationsContentType],
 			supersededCheckbackPeriod: test.superSeededPeriod,
 		}
 
@@ -431,7 +876,7 @@ func Test_CloseSupersededTransactions(t *testing.T) {
 		}
 
 		// Execute superseded check operation
-		am.CloseSupersededTransactions(test.completedTxs, test.refInterval)
+		am.CloseSupersededTransactions(context.Background(), test.completedTxs, test.refInterval)
 
 		// Verifications - check that the mock object was called with the previously specified parameters
 		readerMock.AssertExpectations(t)
@@ -453,6 +898,7 @@ func Test_CloseSupersededTransactions_ComplexScenario(t *testing.T) {
 	var readerMock = new(eventReaderMock)
 	am := AnnotationsMonitoringService{
 		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
 		supersededCheckbackPeriod: 60,
 	}
 
@@ -467,12 +913,13 @@ func Test_CloseSupersededTransactions_ComplexScenario(t *testing.T) {
 		transactionEvent{
 			TransactionID: "tid1_2",
 			UUID:          "uuid1",
-			Events:        []publishEvent{{ContentType: contentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}}}
+			Events:        []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}}}
 
-	readerMock.On("GetTransactionsForUUIDs", strings.ToLower(contentType), uuids, "120m").
+	readerMock.On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), uuids, "120m").
 		Return(returnedTxs, nil)
 
-	am.CloseSupersededTransactions(completedTxs, 60)
+	supersededTids := am.CloseSupersededTransactions(context.Background(), completedTxs, 60)
+	assert.Equal(t, []string{"tid1_2"}, supersededTids)
 
 	// Verifications - check that the mock object was called with the previously specified parameters
 	readerMock.AssertExpectations(t)
@@ -482,7 +929,7 @@ func Test_CloseSupersededTransactions_ComplexScenario(t *testing.T) {
 	assert.Equal(t, "Transaction has been superseded by tid=tid1.", hook.LastEntry().Message)
 	assert.Equal(t, endEvent, hook.LastEntry().Data["event"])
 	assert.Equal(t, "uuid1", hook.LastEntry().Data["uuid"])
-	assert.Equal(t, contentType, hook.LastEntry().Data["content_type"])
+	assert.Equal(t, annotationsContentType, hook.LastEntry().Data["content_type"])
 	assert.Equal(t, "true", hook.LastEntry().Data["monitoring_event"])
 	assert.Equal(t, "tid1_2", hook.LastEntry().Data["transaction_id"])
 	assert.Equal(t, "2017-09-22T11:45:47.23038034Z", hook.LastEntry().Data["startTime"])
@@ -494,6 +941,50 @@ func Test_CloseSupersededTransactions_ComplexScenario(t *testing.T) {
 	assert.True(t, hook.LastEntry().Data["logTime"] != nil)
 }
 
+func Test_CloseSupersededTransactions_Streamed(t *testing.T) {
+
+	_ = logger.NewTestHook("annotations-monitoring-service")
+
+	sink := &recordingSink{}
+	var readerMock = new(streamingReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		supersededCheckbackPeriod: 60,
+		sink:                      sink,
+	}
+
+	completedTxs := []completedTransactionEvent{
+		{TransactionID: "tid3", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z", EndTime: "2017-09-22T12:00:49.23038034Z"},
+	}
+
+	readerMock.On("StreamTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "120m").
+		Return(transactions{
+			{TransactionID: "tid2", UUID: "uuid1", StartTime: "2017-09-22T11:50:47.23038034Z",
+				Events: []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:50:47.23038034Z", Event: startEvent}}},
+			{TransactionID: "tid1", UUID: "uuid1", StartTime: "2017-09-22T11:45:47.23038034Z",
+				Events: []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent}}},
+			// no event of the monitored content type: it can't be superseded
+			{TransactionID: "tid_other", UUID: "uuid1", StartTime: "2017-09-22T11:40:47.23038034Z",
+				Events: []publishEvent{{ContentType: "Content", Time: "2017-09-22T11:40:47.23038034Z", Event: startEvent}}},
+			// the completed transaction itself
+			{TransactionID: "tid3", UUID: "uuid1", StartTime: "2017-09-22T12:00:47.23038034Z",
+				Events: []publishEvent{{ContentType: annotationsContentType, Time: "2017-09-22T12:00:47.23038034Z", Event: startEvent}}},
+		}, nil)
+
+	supersededTids := am.CloseSupersededTransactions(context.Background(), completedTxs, 60)
+
+	// the superseded transactions are closed in the order they have started
+	assert.Equal(t, []string{"tid1", "tid2"}, supersededTids)
+	readerMock.AssertExpectations(t)
+	readerMock.AssertNotCalled(t, "GetTransactionsForUUIDs", mock.Anything, mock.Anything, mock.Anything)
+
+	require.Len(t, sink.records, 2)
+	assert.Equal(t, "2017-09-22T11:45:47.23038034Z", sink.records[0].StartTime)
+	assert.Equal(t, "tid3", sink.records[0].SupersededBy)
+	assert.Equal(t, supersededResult, sink.records[1].Result)
+}
+
 func Test_DetermineLookbackPeriod(t *testing.T) {
 	var tests = []struct {
 		publishEvent            publishEvent
@@ -513,19 +1004,279 @@ func Test_DetermineLookbackPeriod(t *testing.T) {
 		readerMock := new(eventReaderMock)
 		am := AnnotationsMonitoringService{
 			eventReader:       readerMock,
+			contentType:       contentTypeRegistry[annotationsContentType],
 			maxLookbackPeriod: test.maxLookbackPeriod,
 		}
 
-		readerMock.On("GetLatestEvent", strings.ToLower(contentType), mock.AnythingOfType("string")).
+		readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
 			Return(test.publishEvent, test.err)
 
-		lbp := am.DetermineLookbackPeriod()
+		lbp := am.DetermineLookbackPeriod(context.Background())
 
 		readerMock.AssertExpectations(t)
 		assert.Equal(t, test.resultingLookbackPeriod, lbp)
 	}
 }
 
+func Test_DetermineLookbackPeriod_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newFileCheckpointStore(dir)
+	assert.NoError(t, err)
+
+	var tests = []struct {
+		windowEnd               time.Time
+		maxLookbackPeriod       int
+		resultingLookbackPeriod int
+	}{
+		{time.Now().Add(-60 * time.Minute), 4320, 65},
+		{time.Now().Add(-3 * time.Minute), 4320, 10},
+		{time.Now().AddDate(0, 0, -5), 4320, 4320},
+	}
+
+	for _, test := range tests {
+		readerMock := new(eventReaderMock)
+		am := AnnotationsMonitoringService{
+			eventReader:       readerMock,
+			contentType:       contentTypeRegistry[annotationsContentType],
+			maxLookbackPeriod: test.maxLookbackPeriod,
+			checkpoints:       store,
+		}
+		assert.NoError(t, store.Save(annotationsContentType, checkpoint{WindowEnd: test.windowEnd}))
+
+		lbp := am.DetermineLookbackPeriod(context.Background())
+
+		// the event reader shouldn't be called when there is a checkpoint
+		readerMock.AssertNotCalled(t, "GetLatestEvent", mock.Anything, mock.Anything)
+		assert.Equal(t, test.resultingLookbackPeriod, lbp)
+	}
+}
+
+func Test_CloseCompletedTransactions_SavesCheckpoint(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newFileCheckpointStore(dir)
+	assert.NoError(t, err)
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod:         4320,
+		supersededCheckbackPeriod: 60,
+		checkpoints:               store,
+		closedTxs:                 newClosedTransactionIndex(time.Hour),
+	}
+
+	txs := transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid2",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+			}},
+	}
+
+	// no checkpoint yet: the lookback period is determined through the event reader
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), "4320m").
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "1505m").
+		Return(transactions{}, nil)
+
+	before := time.Now()
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	cp, found, err := store.Load(annotationsContentType)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.False(t, cp.WindowEnd.Before(before))
+	assert.Equal(t, 1, len(cp.ClosedTransactions))
+	assert.Contains(t, cp.ClosedTransactions, "tid1")
+
+	// the next cycle resumes from the checkpoint
+	assert.Equal(t, 10, am.DetermineLookbackPeriod(context.Background()))
+	readerMock.AssertNumberOfCalls(t, "GetLatestEvent", 1)
+}
+
+func Test_CloseCompletedTransactions_Sliced(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newFileCheckpointStore(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(annotationsContentType, checkpoint{WindowEnd: time.Now().Add(-3 * time.Hour)}))
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		maxLookbackPeriod:         4320,
+		supersededCheckbackPeriod: 60,
+		lookbackSlice:             time.Hour,
+		checkpoints:               store,
+		closedTxs:                 newClosedTransactionIndex(time.Hour),
+	}
+
+	txs := transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:47.23038034Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:49.23038034Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:53.23038034Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+	}
+
+	// the 185 minutes window is fetched in 4 slices, the third of which fails
+	readerMock.On("GetTransactionsBetween", strings.ToLower(annotationsContentType), mock.Anything, mock.Anything).
+		Return(txs, nil).Once().
+		On("GetTransactionsBetween", strings.ToLower(annotationsContentType), mock.Anything, mock.Anything).
+		Return(transactions{}, nil).Once().
+		On("GetTransactionsBetween", strings.ToLower(annotationsContentType), mock.Anything, mock.Anything).
+		Return(transactions(nil), errors.New("timed out")).Once().
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, mock.AnythingOfType("string")).
+		Return(transactions{}, nil)
+
+	summary := am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+	readerMock.AssertNumberOfCalls(t, "GetTransactionsBetween", 3)
+	assert.Equal(t, 185, summary.LookbackPeriod)
+	assert.Equal(t, 1, summary.Transactions)
+	assert.Equal(t, 1, summary.Closed)
+	assert.True(t, summary.Failed)
+
+	// the slices are contiguous and fetched in chronological order
+	var slices [][]time.Time
+	for _, call := range readerMock.Calls {
+		if call.Method == "GetTransactionsBetween" {
+			slices = append(slices, []time.Time{call.Arguments.Get(1).(time.Time), call.Arguments.Get(2).(time.Time)})
+		}
+	}
+	for i, slice := range slices {
+		assert.Equal(t, time.Hour, slice[1].Sub(slice[0]))
+		if i > 0 {
+			assert.True(t, slice[0].Equal(slices[i-1][1]))
+		}
+	}
+
+	// the failed slice is the first one fetched by the next cycle
+	cp, found, err := store.Load(annotationsContentType)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, cp.WindowEnd.Equal(slices[1][1]))
+	assert.Contains(t, cp.ClosedTransactions, "tid1")
+	assert.Equal(t, 70, am.DetermineLookbackPeriod(context.Background()))
+}
+
+func Test_CloseCompletedTransactions_SuppressesDuplicates(t *testing.T) {
+
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	var readerMock = new(eventReaderMock)
+	am := AnnotationsMonitoringService{
+		eventReader:               readerMock,
+		contentType:               contentTypeRegistry[annotationsContentType],
+		supersededCheckbackPeriod: 60,
+		closedTxs:                 newClosedTransactionIndex(time.Hour),
+	}
+
+	txs := transactions{
+		transactionEvent{
+			TransactionID: "tid1",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:45:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:45:00.00000000Z", Event: startEvent},
+			}},
+		transactionEvent{
+			TransactionID: "tid2",
+			UUID:          "uuid1",
+			StartTime:     "2017-09-22T11:55:00.00000000Z",
+			Events: []publishEvent{
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:00.00000000Z", Event: startEvent},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:02.00000000Z", IsValid: "true", Event: "Map"},
+				{ContentType: annotationsContentType, Time: "2017-09-22T11:55:04.00000000Z", Event: completenessCriteriaEvent, Level: "info"},
+			}},
+	}
+
+	// the event reader lags behind: the same transactions are returned on every cycle
+	readerMock.On("GetLatestEvent", strings.ToLower(annotationsContentType), mock.AnythingOfType("string")).
+		Return(publishEvent{Time: time.Now().AddDate(0, 0, -1).Format(defaultTimestampFormat)}, nil).
+		On("GetTransactions", strings.ToLower(annotationsContentType), "1445m").
+		Return(txs, nil).
+		On("GetTransactionsForUUIDs", strings.ToLower(annotationsContentType), []string{"uuid1"}, "1505m").
+		Return(txs, nil)
+
+	am.CloseCompletedTransactions(context.Background())
+
+	entries := hook.AllEntries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "Transaction has finished", entries[0].Message)
+	assert.Equal(t, "Transaction has been superseded by tid=tid2.", entries[1].Message)
+
+	hook.Reset()
+	am.CloseCompletedTransactions(context.Background())
+
+	readerMock.AssertExpectations(t)
+
+	assert.Equal(t, 1, len(hook.AllEntries()))
+	assert.Equal(t, "info", hook.LastEntry().Level.String())
+	assert.Equal(t, "Transactions that had already been closed have been skipped.", hook.LastEntry().Message)
+	assert.Equal(t, 2, hook.LastEntry().Data["suppressed_duplicates"])
+	assert.Equal(t, 2, hook.LastEntry().Data["total_suppressed_duplicates"])
+	assert.Equal(t, 2, am.closedTxs.suppressedDuplicates())
+}
+
+func Test_RestoreClosedTransactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := newFileCheckpointStore(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(annotationsContentType, checkpoint{
+		WindowEnd: time.Now(),
+		ClosedTransactions: map[string]time.Time{
+			"tid1": time.Now().Add(-time.Minute),
+			"tid2": time.Now().AddDate(0, 0, -1),
+		},
+	}))
+
+	am := AnnotationsMonitoringService{
+		contentType: contentTypeRegistry[annotationsContentType],
+		checkpoints: store,
+		closedTxs:   newClosedTransactionIndex(time.Hour),
+	}
+
+	am.RestoreClosedTransactions()
+
+	assert.True(t, am.closedTxs.isDuplicate("tid1"))
+	assert.False(t, am.closedTxs.isDuplicate("tid2"))
+}
+
 func Test_earlierTransaction(t *testing.T) {
 	var tests = []struct {
 		unknown_tx   transactionEvent
@@ -548,7 +1299,7 @@ func Test_earlierTransaction(t *testing.T) {
 			UUID:          "uuid1",
 			Events: []publishEvent{
 				publishEvent{
-					ContentType: contentType,
+					ContentType: annotationsContentType,
 					Event:       startEvent,
 					Time:        "2017-09-22T12:31:47.23038034Z",
 				},
@@ -559,7 +1310,7 @@ func Test_earlierTransaction(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		b, st := earlierTransaction(test.unknown_tx, test.completed_tx)
+		b, st := earlierTransaction(test.unknown_tx, test.completed_tx, contentTypeRegistry[annotationsContentType])
 		assert.Equal(t, b, test.isEarlier)
 		assert.Equal(t, st, test.startTime)
 	}
@@ -592,17 +1343,53 @@ type eventReaderMock struct {
 	mock.Mock
 }
 
-func (e *eventReaderMock) GetTransactions(contentType string, lookbackPeriod string) (transactions, error) {
+func (e *eventReaderMock) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
 	args := e.Called(contentType, lookbackPeriod)
 	return args.Get(0).(transactions), args.Error(1)
 }
 
-func (e *eventReaderMock) GetTransactionsForUUIDs(contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+func (e *eventReaderMock) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	args := e.Called(contentType, uuids, lookbackPeriod)
+	return args.Get(0).(transactions), args.Error(1)
+}
+
+func (e *eventReaderMock) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
 	args := e.Called(contentType, uuids, lookbackPeriod)
 	return args.Get(0).(transactions), args.Error(1)
 }
 
-func (e *eventReaderMock) GetLatestEvent(contentType string, lookbackPeriod string) (publishEvent, error) {
+func (e *eventReaderMock) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	args := e.Called(contentType, earliest, latest)
+	return args.Get(0).(transactions), args.Error(1)
+}
+
+func (e *eventReaderMock) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
 	args := e.Called(contentType, lookbackPeriod)
 	return args.Get(0).(publishEvent), args.Error(1)
 }
+
+func (e *eventReaderMock) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	args := e.Called(contentType, transactionID, lookbackPeriod)
+	return args.Get(0).(transactionEvent), args.Error(1)
+}
+
+// streamingReaderMock hands the transactions over one at a time, as a TransactionStreamer.
+type streamingReaderMock struct {
+	eventReaderMock
+}
+
+func (e *streamingReaderMock) StreamTransactions(ctx context.Context, contentType string, lookbackPeriod string, handle func(tx transactionEvent)) error {
+	args := e.Called(contentType, lookbackPeriod)
+	for _, tx := range args.Get(0).(transactions) {
+		handle(tx)
+	}
+	return args.Error(1)
+}
+
+func (e *streamingReaderMock) StreamTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string, handle func(tx transactionEvent)) error {
+	args := e.Called(contentType, uuids, lookbackPeriod)
+	for _, tx := range args.Get(0).(transactions) {
+		handle(tx)
+	}
+	return args.Error(1)
+}
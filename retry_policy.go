@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+)
+
+// retryPolicy defines how the failed event reader requests are retried.
+// The zero value makes a single attempt.
+type retryPolicy struct {
+	maxAttempts int
+	// delay before the first retry; it is doubled for every following one
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// fraction (0-1) of the delay that is randomised, so that the retries of the different clients are spread
+	jitter               float64
+	retryableStatusCodes []int
+}
+
+func (p retryPolicy) attempts() int {
+	if p.maxAttempts < 1 {
+		return 1
+	}
+	return p.maxAttempts
+}
+
+func (p retryPolicy) isRetryableStatus(statusCode int) bool {
+	for _, code := range p.retryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry (starting from 1).
+func (p retryPolicy) backoff(retry int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < retry && (p.maxDelay <= 0 || delay < p.maxDelay); i++ {
+		delay *= 2
+	}
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+
+	if p.jitter > 0 {
+		delay += time.Duration(p.jitter * float64(delay) * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// eventReaderCalls configures how the requests of the HTTP event readers are made.
+type eventReaderCalls struct {
+	// shared with the healthcheck; the default client is used if nil
+	httpClient  *http.Client
+	retryPolicy retryPolicy
+	// deadline of every call, retries included; no deadline if zero
+	timeout time.Duration
+}
+
+// withTimeout applies the deadline of an event reader call; there is no deadline if it is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// do executes the request with the client (the default one if nil), retrying it according to the policy;
+// the response of the last attempt is returned.
+func (p retryPolicy) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	attempts := p.attempts()
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt == attempts || (err == nil && !p.isRetryableStatus(resp.StatusCode)) {
+			return resp, err
+		}
+		// cancelled or past the deadline: there is no point in retrying
+		if err != nil && req.Context().Err() != nil {
+			return nil, err
+		}
+
+		delay := p.backoff(attempt)
+		fields := map[string]interface{}{
+			"url":     req.URL.String(),
+			"attempt": attempt,
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		} else {
+			fields["status code"] = resp.StatusCode
+			if d, found := retryAfter(resp); found {
+				// the event reader has asked for a longer pause than the policy allows: don't retry
+				if p.maxDelay > 0 && d > p.maxDelay {
+					return resp, nil
+				}
+				if d > delay {
+					delay = d
+				}
+			}
+			cleanUp(resp)
+		}
+
+		logger.Warnf(fields, "Event reader request has failed, retrying in %v.", delay)
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryAfter parses the Retry-After header of the response, given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		delay := time.Until(t)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
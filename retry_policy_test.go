@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Attempts(t *testing.T) {
+	assert.Equal(t, 1, retryPolicy{}.attempts())
+	assert.Equal(t, 3, retryPolicy{maxAttempts: 3}.attempts())
+}
+
+func TestRetryPolicy_BackoffIsExponentialAndCapped(t *testing.T) {
+	p := retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, p.backoff(3))
+	assert.Equal(t, time.Second, p.backoff(5))
+	assert.Equal(t, time.Second, p.backoff(100))
+}
+
+func TestRetryPolicy_BackoffJitter(t *testing.T) {
+	p := retryPolicy{baseDelay: time.Second, maxDelay: time.Minute, jitter: 0.2}
+
+	for i := 0; i < 100; i++ {
+		delay := p.backoff(1)
+		assert.True(t, delay >= 800*time.Millisecond && delay <= 1200*time.Millisecond, "delay out of range: %v", delay)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	_, found := retryAfter(resp)
+	assert.False(t, found)
+
+	resp.Header.Set("Retry-After", "7")
+	delay, found := retryAfter(resp)
+	assert.True(t, found)
+	assert.Equal(t, 7*time.Second, delay)
+
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	delay, found = retryAfter(resp)
+	assert.True(t, found)
+	assert.True(t, delay > 59*time.Minute && delay <= time.Hour)
+
+	resp.Header.Set("Retry-After", "soon")
+	_, found = retryAfter(resp)
+	assert.False(t, found)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/IBM/sarama"
+)
+
+const kafkaEventReaderType = "kafka"
+
+// streamEventReader keeps the open transactions built from the consumed publish events; it answers the event reader calls,
+// so that the superseded check, the timeouts and the scheduled cycles work on the streamed transactions as they do on Splunk.
+type streamEventReader struct {
+	sync.Mutex
+	txs map[string]*streamedTransaction
+	// transaction IDs per UUID, so that the transactions of a content are found without going through all of them
+	tidsByUUID map[string]map[string]bool
+	// the closed transactions, so that their late events (e.g. NotifyKafka) don't open them again
+	closed map[string]time.Time
+	// the transactions that haven't received any event for that long are forgotten, and so are the closed ones
+	retention  time.Duration
+	lastExpiry time.Time
+}
+
+type streamedTransaction struct {
+	tx        transactionEvent
+	updatedAt time.Time
+	// whether the start event of the transaction has been consumed
+	started bool
+}
+
+func newStreamEventReader(retention time.Duration) *streamEventReader {
+	return &streamEventReader{
+		txs:        make(map[string]*streamedTransaction),
+		tidsByUUID: make(map[string]map[string]bool),
+		closed:     make(map[string]time.Time),
+		retention:  retention,
+		lastExpiry: time.Now(),
+	}
+}
+
+// add records the event in its transaction, and returns the transaction as it is now; the transaction starts with the given
+// start event of its content type, or with its earliest event until the start event has been consumed. The events of the
+// closed transactions are dropped.
+func (r *streamEventReader) add(event publishEvent, startEvent string) (transactionEvent, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastExpiry) > time.Minute {
+		r.expire(now)
+	}
+	if _, closed := r.closed[event.TransactionID]; closed {
+		return transactionEvent{}, false
+	}
+
+	streamed, found := r.txs[event.TransactionID]
+	if !found {
+		streamed = &streamedTransaction{tx: transactionEvent{TransactionID: event.TransactionID, StartTime: event.Time, start: event.parsedTime()}}
+		r.txs[event.TransactionID] = streamed
+	}
+
+	tx := &streamed.tx
+	if tx.UUID == "" && event.UUID != "" {
+		tx.UUID = event.UUID
+		if r.tidsByUUID[tx.UUID] == nil {
+			r.tidsByUUID[tx.UUID] = make(map[string]bool)
+		}
+		r.tidsByUUID[tx.UUID][tx.TransactionID] = true
+	}
+	tx.Events = append(tx.Events, event)
+	tx.EventCount++
+	if event.Event == startEvent || (!streamed.started && event.parsedTime().Before(tx.startedAt())) {
+		tx.StartTime, tx.start = event.Time, event.parsedTime()
+	}
+	streamed.started = streamed.started || event.Event == startEvent
+	streamed.updatedAt = now
+
+	return copyTransaction(*tx), true
+}
+
+// remove forgets a transaction, once it has been closed.
+func (r *streamEventReader) remove(tid string) {
+	r.Lock()
+	defer r.Unlock()
+	r.forget(tid)
+	r.closed[tid] = time.Now()
+}
+
+func (r *streamEventReader) expire(now time.Time) {
+	for tid, streamed := range r.txs {
+		if now.Sub(streamed.updatedAt) > r.retention {
+			r.forget(tid)
+		}
+	}
+	for tid, closedAt := range r.closed {
+		if now.Sub(closedAt) > r.retention {
+			delete(r.closed, tid)
+		}
+	}
+	r.lastExpiry = now
+}
+
+func (r *streamEventReader) forget(tid string) {
+	streamed, found := r.txs[tid]
+	if !found {
+		return
+	}
+	delete(r.txs, tid)
+
+	if tids := r.tidsByUUID[streamed.tx.UUID]; tids != nil {
+		delete(tids, tid)
+		if len(tids) == 0 {
+			delete(r.tidsByUUID, streamed.tx.UUID)
+		}
+	}
+}
+
+// selected copies the transactions of the content type that are matched by the filter, within the lock, so that only
+// the matches are copied; only the transactions of the contents are gone through if there are UUIDs.
+func (r *streamEventReader) selected(contentType string, uuids []string, filter func(tx transactionEvent) bool) transactions {
+	r.Lock()
+	defer r.Unlock()
+
+	txs := transactions{}
+	match := func(tx transactionEvent) {
+		if isOfContentType(tx, contentType) && filter(tx) {
+			txs = append(txs, copyTransaction(tx))
+		}
+	}
+
+	if len(uuids) == 0 {
+		for _, streamed := range r.txs {
+			match(streamed.tx)
+		}
+		return txs
+	}
+	for _, uuid := range uuids {
+		for tid := range r.tidsByUUID[uuid] {
+			match(r.txs[tid].tx)
+		}
+	}
+	return txs
+}
+
+func copyTransaction(tx transactionEvent) transactionEvent {
+	tx.Events = append([]publishEvent{}, tx.Events...)
+	return tx
+}
+
+func (r *streamEventReader) GetTransactions(ctx context.Context, contentType string, lookbackPeriod string) (transactions, error) {
+	return r.GetTransactionsForUUIDs(ctx, contentType, nil, lookbackPeriod)
+}
+
+// GetTransactionsForUUIDs returns the transactions that have started within the lookback period: they are all open.
+func (r *streamEventReader) GetTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.selected(contentType, uuids, func(tx transactionEvent) bool {
+		return !tx.startedAt().Before(earliest)
+	}), nil
+}
+
+// GetAllTransactionsForUUIDs only knows about the open transactions: the closed ones are forgotten.
+func (r *streamEventReader) GetAllTransactionsForUUIDs(ctx context.Context, contentType string, uuids []string, lookbackPeriod string) (transactions, error) {
+	txs, err := r.GetTransactionsForUUIDs(ctx, contentType, uuids, lookbackPeriod)
+	if err != nil {
+		return txs, err
+	}
+	return txs, errClosedTransactionsUnavailable
+}
+
+func (r *streamEventReader) GetTransactionsBetween(ctx context.Context, contentType string, earliest time.Time, latest time.Time) (transactions, error) {
+	return r.selected(contentType, nil, func(tx transactionEvent) bool {
+		return !tx.startedAt().Before(earliest) && tx.startedAt().Before(latest)
+	}), nil
+}
+
+func (r *streamEventReader) GetLatestEvent(ctx context.Context, contentType string, lookbackPeriod string) (publishEvent, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return publishEvent{}, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	var latest publishEvent
+	var found bool
+	for _, streamed := range r.txs {
+		for _, event := range streamed.tx.Events {
+			if event.parsedTime().Before(earliest) || !strings.EqualFold(event.ContentType, contentType) {
+				continue
+			}
+			if !found || later(event, latest) {
+				latest, found = event, true
+			}
+		}
+	}
+
+	if !found {
+		return publishEvent{}, errors.New("Failed to retrieve latest log event")
+	}
+	return latest, nil
+}
+
+func (r *streamEventReader) GetTransaction(ctx context.Context, contentType string, transactionID string, lookbackPeriod string) (transactionEvent, error) {
+	earliest, err := earliestTime(lookbackPeriod)
+	if err != nil {
+		return transactionEvent{}, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	streamed, found := r.txs[transactionID]
+	if !found || streamed.tx.startedAt().Before(earliest) || !isOfContentType(streamed.tx, contentType) {
+		return transactionEvent{}, errTransactionNotFound
+	}
+	return copyTransaction(streamed.tx), nil
+}
+
+// streamingSink sends the records of the closed transactions to the next sink, then forgets the transactions
+// that have been sent to at least one of its sinks.
+type streamingSink struct {
+	next  CompletionSink
+	state *streamEventReader
+}
+
+func (s streamingSink) Send(record completionRecord) error {
+	err := s.next.Send(record)
+	// a transaction that couldn't be sent anywhere is kept, to be closed again
+	var partial partialSendError
+	if err == nil || errors.As(err, &partial) {
+		s.state.remove(record.TransactionID)
+	}
+	return err
+}
+
+func (s streamingSink) Close() error {
+	return s.next.Close()
+}
+
+// streamingHandler closes the transactions as soon as the consumed events complete them.
+type streamingHandler struct {
+	// services per lower case content type
+	services map[string]AnnotationsMonitoringService
+	state    *streamEventReader
+}
+
+func newStreamingHandler(services []AnnotationsMonitoringService, state *streamEventReader) *streamingHandler {
+	h := &streamingHandler{services: make(map[string]AnnotationsMonitoringService), state: state}
+	for _, service := range services {
+		h.services[service.contentType.readerContentType()] = service
+	}
+	return h
+}
+
+// process adds the event to its transaction and closes the transaction if it is complete or has failed, along with the
+// transactions of the same content it supersedes.
+func (h *streamingHandler) process(ctx context.Context, message []byte) {
+	var event publishEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		logger.Warnf(map[string]interface{}{"error": err.Error()}, "Publish event couldn't be decoded, it is skipped.")
+		return
+	}
+
+	service, found := h.services[strings.ToLower(event.ContentType)]
+	if !found || event.TransactionID == "" {
+		return
+	}
+
+	// the transaction has already been closed, by a previous run or another monitoring
+	if event.Event == endEvent {
+		h.state.remove(event.TransactionID)
+		return
+	}
+
+	tx, open := h.state.add(event, service.contentType.startEvent)
+	if !open {
+		return
+	}
+
+	// the transactions of the content type are closed one at a time, whether by the stream or by a cycle
+	if err := service.cycleLock.lock(ctx); err != nil {
+		return
+	}
+	defer service.cycleLock.unlock()
+
+	var summary cycleSummary
+	service.closeTransactions(ctx, transactions{tx}, int(h.state.retention.Minutes()), &summary)
+}
+
+func (h *streamingHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *streamingHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *streamingHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			h.process(session.Context(), message.Value)
+			session.MarkMessage(message, "")
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// publishEventsConsumer consumes the publish events of a Kafka topic, within a consumer group.
+type publishEventsConsumer struct {
+	group   sarama.ConsumerGroup
+	topic   string
+	handler sarama.ConsumerGroupHandler
+
+	sync.Mutex
+	lastErr error
+}
+
+func newPublishEventsConsumer(brokers []string, topic, groupID string, handler sarama.ConsumerGroupHandler) (*publishEventsConsumer, error) {
+	if len(brokers) == 0 || topic == "" || groupID == "" {
+		return nil, errors.New("no brokers, topic or consumer group have been configured for the kafka event reader")
+	}
+
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		return nil, err
+	}
+	return &publishEventsConsumer{group: group, topic: topic, handler: handler}, nil
+}
+
+// run consumes the topic until the context is done; the consumer group is then closed.
+func (c *publishEventsConsumer) run(ctx context.Context) {
+	go func() {
+		for err := range c.group.Errors() {
+			logger.Errorf(map[string]interface{}{"topic": c.topic}, err, "Consuming publish events has failed.")
+		}
+	}()
+
+	for ctx.Err() == nil {
+		// Consume blocks while the partitions are consumed, and returns on every rebalance
+		c.setError(nil)
+		err := c.group.Consume(ctx, []string{c.topic}, c.handler)
+		if err != nil && ctx.Err() == nil {
+			c.setError(err)
+			logger.Errorf(map[string]interface{}{"topic": c.topic}, err, "Consuming publish events has failed, retrying.")
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	if err := c.group.Close(); err != nil {
+		logger.Errorf(map[string]interface{}{"topic": c.topic}, err, "Closing the publish events consumer has failed.")
+	}
+}
+
+func (c *publishEventsConsumer) setError(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.lastErr = err
+}
+
+// err returns the error of the last consumption attempt, nil if the topic is being consumed.
+func (c *publishEventsConsumer) err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.lastErr
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStreamingHandler(sink *collectingSink) *streamingHandler {
+	state := newStreamEventReader(time.Hour)
+	services := newMonitoringServices(&monitoringConfig{
+		eventReader:               state,
+		contentTypes:              []contentTypeConfig{contentTypeRegistry[annotationsContentType], contentTypeRegistry[suggestionsContentType]},
+		maxLookbackPeriod:         60,
+		supersededCheckbackPeriod: 60,
+		sink:                      streamingSink{next: sink, state: state},
+	})
+	return newStreamingHandler(services, state)
+}
+
+func streamedEvent(t *testing.T, tid, uuid, event, isValid string, at time.Time) []byte {
+	b, err := json.Marshal(publishEvent{
+		ContentType:   annotationsContentType,
+		Event:         event,
+		IsValid:       isValid,
+		Level:         "info",
+		Time:          at.Format(defaultTimestampFormat),
+		TransactionID: tid,
+		UUID:          uuid,
+	})
+	assert.NoError(t, err)
+	return b
+}
+
+func Test_streamingHandler_ClosesTransactionsOnCompletion(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	sink := &collectingSink{next: loggerSink{}}
+	h := newTestStreamingHandler(sink)
+	start := time.Now().Add(-time.Minute).UTC()
+
+	h.process(context.Background(), streamedEvent(t, "tid_1", "uuid1", startEvent, "", start))
+	h.process(context.Background(), streamedEvent(t, "tid_2", "uuid1", startEvent, "", start.Add(time.Second)))
+	h.process(context.Background(), streamedEvent(t, "tid_2", "uuid1", "Map", "true", start.Add(2*time.Second)))
+	assert.Empty(t, sink.collected())
+
+	// the completeness criteria are met: the transaction is closed right away, along with the one it supersedes
+	h.process(context.Background(), streamedEvent(t, "tid_2", "uuid1", completenessCriteriaEvent, "", start.Add(3*time.Second)))
+
+	records := sink.collected()
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "tid_2", records[0].TransactionID)
+	assert.Equal(t, completedResult, records[0].Result)
+	assert.Equal(t, 2*time.Second, records[0].Duration)
+	assert.Equal(t, "tid_1", records[1].TransactionID)
+	assert.Equal(t, supersededResult, records[1].Result)
+	assert.Equal(t, "tid_2", records[1].SupersededBy)
+
+	// the closed transactions are forgotten, and their late events don't open them again
+	h.process(context.Background(), streamedEvent(t, "tid_2", "uuid1", "NotifyKafka", "", start.Add(4*time.Second)))
+	txs, err := h.state.GetTransactions(context.Background(), "annotations", "60m")
+	assert.NoError(t, err)
+	assert.Empty(t, txs)
+	assert.Equal(t, 2, len(sink.collected()))
+
+	// the log format is the same as when polling
+	assert.Equal(t, "Transaction has been superseded by tid=tid_2.", hook.LastEntry().Message)
+	assert.Equal(t, endEvent, hook.LastEntry().Data["event"])
+}
+
+func Test_streamingHandler_SkipsOtherEvents(t *testing.T) {
+	hook := logger.NewTestHook("annotations-monitoring-service")
+
+	sink := &collectingSink{}
+	h := newTestStreamingHandler(sink)
+	now := time.Now().UTC()
+
+	h.process(context.Background(), []byte(`{"transaction_id": `))
+	assert.Equal(t, "Publish event couldn't be decoded, it is skipped.", hook.LastEntry().Message)
+
+	h.process(context.Background(), []byte(`{"content_type": "Concepts", "transaction_id": "tid_1", "event": "PublishStart"}`))
+	h.process(context.Background(), streamedEvent(t, "", "uuid1", startEvent, "", now))
+
+	// a PublishEnd event means that the transaction has already been closed
+	h.process(context.Background(), streamedEvent(t, "tid_2", "uuid2", startEvent, "", now))
+	h.process(context.Background(), streamedEvent(t, "tid_2", "uuid2", endEvent, "", now))
+
+	txs, err := h.state.GetTransactions(context.Background(), "annotations", "60m")
+	assert.NoError(t, err)
+	assert.Empty(t, txs)
+	assert.Empty(t, sink.collected())
+}
+
+func Test_streamEventReader_ExpiresStaleTransactions(t *testing.T) {
+	state := newStreamEventReader(time.Hour)
+	state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_1", Event: startEvent, Time: time.Now().Format(defaultTimestampFormat)}, startEvent)
+	state.txs["tid_1"].updatedAt = time.Now().Add(-2 * time.Hour)
+	state.closed["tid_0"] = time.Now().Add(-2 * time.Hour)
+	state.lastExpiry = time.Now().Add(-2 * time.Minute)
+
+	state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_2", Event: startEvent, Time: time.Now().Format(defaultTimestampFormat)}, startEvent)
+
+	assert.NotContains(t, state.txs, "tid_1")
+	assert.Contains(t, state.txs, "tid_2")
+	assert.NotContains(t, state.closed, "tid_0")
+}
+
+func Test_streamEventReader_StartTime(t *testing.T) {
+	state := newStreamEventReader(time.Hour)
+	add := func(event, at string) transactionEvent {
+		tx, _ := state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_1", Event: event, Time: at}, "IngestStart")
+		return tx
+	}
+
+	// the fraction of the timestamps is trimmed: they are compared once parsed
+	assert.Equal(t, "2017-09-22T11:50:50Z", add(mapperEvent, "2017-09-22T11:50:50Z").StartTime)
+	assert.Equal(t, "2017-09-22T11:50:50Z", add(mapperEvent, "2017-09-22T11:50:50.5Z").StartTime)
+	assert.Equal(t, "2017-09-22T11:50:49Z", add(startEvent, "2017-09-22T11:50:49Z").StartTime)
+
+	// only the start event of the content type starts the transaction
+	assert.Equal(t, "2017-09-22T11:50:55Z", add("IngestStart", "2017-09-22T11:50:55Z").StartTime)
+	assert.Equal(t, "2017-09-22T11:50:55Z", add(mapperEvent, "2017-09-22T11:50:40Z").StartTime)
+}
+
+func Test_streamEventReader_IndexesTransactionsByUUID(t *testing.T) {
+	state := newStreamEventReader(time.Hour)
+	now := time.Now().UTC()
+	state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_1", UUID: "uuid1", Event: startEvent, Time: now.Format(defaultTimestampFormat)}, startEvent)
+	state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_2", UUID: "uuid1", Event: startEvent, Time: now.Format(defaultTimestampFormat)}, startEvent)
+	state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_3", UUID: "uuid2", Event: startEvent, Time: now.Format(defaultTimestampFormat)}, startEvent)
+
+	txs, err := state.GetTransactionsForUUIDs(context.Background(), "annotations", []string{"uuid1"}, "60m")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tid_1", "tid_2"}, transactionIDs(txs))
+
+	state.remove("tid_1")
+	txs, err = state.GetAllTransactionsForUUIDs(context.Background(), "annotations", []string{"uuid1"}, "60m")
+	assert.Equal(t, errClosedTransactionsUnavailable, err)
+	assert.Equal(t, []string{"tid_2"}, transactionIDs(txs))
+
+	// the expired transactions leave the index too
+	state.txs["tid_2"].updatedAt = now.Add(-2 * time.Hour)
+	state.Lock()
+	state.expire(time.Now())
+	state.Unlock()
+	assert.NotContains(t, state.tidsByUUID, "uuid1")
+	assert.Equal(t, map[string]bool{"tid_3": true}, state.tidsByUUID["uuid2"])
+}
+
+func Test_streamEventReader_CopiesOnlyTheMatches(t *testing.T) {
+	state := newStreamEventReader(time.Hour)
+	now := time.Now().UTC()
+	for i, tid := range []string{"tid_1", "tid_2", "tid_3"} {
+		state.add(publishEvent{ContentType: annotationsContentType, TransactionID: tid, UUID: "uuid1", Event: startEvent,
+			Time: now.Add(time.Duration(i-3) * time.Minute).Format(defaultTimestampFormat)}, startEvent)
+	}
+	state.add(publishEvent{ContentType: suggestionsContentType, TransactionID: "tid_4", UUID: "uuid1", Event: startEvent,
+		Time: now.Format(defaultTimestampFormat)}, startEvent)
+
+	txs, err := state.GetTransactionsBetween(context.Background(), "annotations", now.Add(-150*time.Second), now)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tid_2", "tid_3"}, transactionIDs(txs))
+
+	event, err := state.GetLatestEvent(context.Background(), "annotations", "60m")
+	assert.NoError(t, err)
+	assert.Equal(t, "tid_3", event.TransactionID)
+	_, err = state.GetLatestEvent(context.Background(), "concepts", "60m")
+	assert.Error(t, err)
+
+	tx, err := state.GetTransaction(context.Background(), "annotations", "tid_1", "60m")
+	assert.NoError(t, err)
+	_, err = state.GetTransaction(context.Background(), "annotations", "tid_4", "60m")
+	assert.Equal(t, errTransactionNotFound, err)
+	_, err = state.GetTransaction(context.Background(), "annotations", "tid_1", "2m")
+	assert.Equal(t, errTransactionNotFound, err)
+
+	// the returned transactions don't share their events with the ones in memory
+	tx.Events[0].Event = "Map"
+	txs[0].Events[0].Event = "Map"
+	for _, streamed := range state.txs {
+		assert.Equal(t, startEvent, streamed.tx.Events[0].Event)
+	}
+}
+
+func Test_streamingSink_KeepsTransactionsThatCouldNotBeSent(t *testing.T) {
+	for name, test := range map[string]struct {
+		err       error
+		forgotten bool
+	}{
+		"sent":           {nil, true},
+		"partially sent": {partialSendError{errors.New("webhook is unavailable")}, true},
+		"not sent":       {errors.New("webhook is unavailable"), false},
+	} {
+		state := newStreamEventReader(time.Hour)
+		state.add(publishEvent{ContentType: annotationsContentType, TransactionID: "tid_1", UUID: "uuid1", Event: startEvent, Time: time.Now().Format(defaultTimestampFormat)}, startEvent)
+
+		sink := streamingSink{next: &recordingSink{err: test.err}, state: state}
+		assert.Equal(t, test.err, sink.Send(completionRecord{TransactionID: "tid_1", UUID: "uuid1"}), name)
+
+		_, found := state.txs["tid_1"]
+		assert.Equal(t, !test.forgotten, found, name)
+	}
+}
+
+func Test_streamingHandler_ConsumeClaim(t *testing.T) {
+	logger.NewTestHook("annotations-monitoring-service")
+
+	sink := &collectingSink{}
+	h := newTestStreamingHandler(sink)
+	now := time.Now().UTC()
+
+	messages := make(chan *sarama.ConsumerMessage, 2)
+	messages <- &sarama.ConsumerMessage{Offset: 1, Value: streamedEvent(t, "tid_1", "uuid1", startEvent, "", now)}
+	messages <- &sarama.ConsumerMessage{Offset: 2, Value: streamedEvent(t, "tid_1", "uuid1", "Map", "false", now)}
+	close(messages)
+
+	session := &fakeConsumerGroupSession{ctx: context.Background()}
+	assert.NoError(t, h.ConsumeClaim(session, fakeConsumerGroupClaim{messages}))
+
+	assert.Equal(t, []int64{1, 2}, session.marked)
+	assert.Equal(t, 1, len(sink.collected()))
+	assert.Equal(t, invalidResult, sink.collected()[0].Result)
+}
+
+func TestPublishEventsConsumerChecker(t *testing.T) {
+	consumer := &publishEventsConsumer{}
+	healthService := newHealthService(&healthConfig{eventReaderType: kafkaEventReaderType, publishEventsConsumer: consumer})
+	assert.Equal(t, "Publish events consumer healthcheck", healthService.checks[0].Name)
+
+	message, err := healthService.checks[0].Checker()
+	assert.Equal(t, "Publish events are consumed", message)
+	assert.NoError(t, err)
+
+	consumer.setError(errors.New("kafka: client has run out of available brokers"))
+	_, err = healthService.checks[0].Checker()
+	assert.Error(t, err)
+}
+
+type fakeConsumerGroupSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeConsumerGroupSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeConsumerGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeConsumerGroupClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c fakeConsumerGroupClaim) Topic() string              { return "publish-events" }
+func (c fakeConsumerGroupClaim) Partition() int32           { return 0 }
+func (c fakeConsumerGroupClaim) InitialOffset() int64       { return 0 }
+func (c fakeConsumerGroupClaim) HighWaterMarkOffset() int64 { return 0 }
+func (c fakeConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
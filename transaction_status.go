@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	openState       = "open"
+	completedState  = "completed"
+	invalidState    = "invalid"
+	supersededState = "superseded"
+	timedOutState   = "timed-out"
+	failedState     = "failed"
+)
+
+// transactionStatus describes the monitoring state of a single transaction.
+type transactionStatus struct {
+	TransactionID string `json:"transaction_id"`
+	UUID          string `json:"uuid"`
+	ContentType   string `json:"content_type"`
+	State         string `json:"state"`
+	// whether the monitoring has already logged the PublishEnd event of the transaction
+	Closed    bool   `json:"closed"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	// until the end of the transaction or, if it hasn't ended, until now
+	Duration float64 `json:"duration_seconds"`
+	// the later transaction of the same content that supersedes this one
+	SupersededBy string         `json:"superseded_by,omitempty"`
+	Events       []publishEvent `json:"events"`
+}
+
+// GetTransactionStatus looks the transaction up through the event reader and determines its state,
+// in the same way as the monitoring cycle does.
+func (s AnnotationsMonitoringService) GetTransactionStatus(ctx context.Context, transactionID string) (transactionStatus, error) {
+	lookbackPeriod := fmt.Sprintf("%dm", s.maxLookbackPeriod+s.supersededCheckbackPeriod)
+	tx, err := s.eventReader.GetTransaction(ctx, s.contentType.readerContentType(), transactionID, lookbackPeriod)
+	if err != nil {
+		return transactionStatus{}, err
+	}
+	return s.transactionStatus(tx, time.Now()), nil
+}
+
+// GetContentHistory returns the status of every transaction of the content within the window, closed or not,
+// in the order they have started. If the event reader only knows the open transactions, their history is returned
+// along with errClosedTransactionsUnavailable.
+func (s AnnotationsMonitoringService) GetContentHistory(ctx context.Context, uuid string, window time.Duration) ([]transactionStatus, error) {
+	txs, err := s.eventReader.GetAllTransactionsForUUIDs(ctx, s.contentType.readerContentType(), []string{uuid}, fmt.Sprintf("%dm", int(window.Minutes())))
+	if err != nil && !errors.Is(err, errClosedTransactionsUnavailable) {
+		return nil, err
+	}
+	sort.Sort(txs)
+
+	now := time.Now()
+	history := make([]transactionStatus, 0, len(txs))
+	for _, tx := range txs {
+		history = append(history, s.transactionStatus(tx, now))
+	}
+	s.linkSupersededTransactions(txs, history)
+
+	return history, err
+}
+
+// linkSupersededTransactions finds, like the superseded check of the monitoring cycle, the transactions that haven't
+// completed before a later one did; they are superseded by the first such completed transaction.
+func (s AnnotationsMonitoringService) linkSupersededTransactions(txs transactions, history []transactionStatus) {
+	for i, completed := range history {
+		if completed.State != completedState && completed.State != invalidState {
+			continue
+		}
+
+		completedTx := completedTransactionEvent{TransactionID: completed.TransactionID, UUID: completed.UUID, StartTime: completed.StartTime, EndTime: completed.EndTime}
+		for j := range history {
+			if i == j || history[j].SupersededBy != "" || !supersedable(history[j]) {
+				continue
+			}
+			if isEarlier, _ := earlierTransaction(txs[j], completedTx, s.contentType); isEarlier {
+				history[j].State = supersededState
+				history[j].SupersededBy = completed.TransactionID
+			}
+		}
+	}
+}
+
+// supersedable checks whether the transaction would be closed by the superseded check of the monitoring cycle.
+func supersedable(status transactionStatus) bool {
+	if status.Closed {
+		return status.State == supersededState
+	}
+	return status.State == openState || status.State == timedOutState
+}
+
+func (s AnnotationsMonitoringService) transactionStatus(tx transactionEvent, now time.Time) transactionStatus {
+	events := append([]publishEvent{}, tx.Events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].parsedTime().Before(events[j].parsedTime()) })
+
+	status := transactionStatus{
+		TransactionID: tx.TransactionID,
+		UUID:          tx.UUID,
+		ContentType:   s.contentType.name,
+		State:         openState,
+		Events:        events,
+	}
+
+	startTime, endTime, isValid := s.evaluateTransaction(tx)
+	status.StartTime = startTime
+
+	if end, closed := publishEndEvent(tx); closed {
+		// the monitoring has already decided: it is recorded in the PublishEnd event
+		status.Closed = true
+		status.State = closedState(end)
+		endTime = end.Time
+	} else if startTime != "" && endTime != "" && isValid != "" {
+		status.State = completedState
+		if isValid != "true" {
+			status.State = invalidState
+		}
+	} else if failure, failed := s.findFailure(tx); failed && startTime != "" {
+		status.State = failedState
+		endTime = failure.Time
+	} else {
+		endTime = ""
+		if st, err := time.Parse(defaultTimestampFormat, startTime); err == nil && s.contentType.timeout > 0 && now.Sub(st) >= s.contentType.timeout {
+			status.State = timedOutState
+		}
+	}
+
+	status.EndTime = endTime
+	if endTime == "" {
+		endTime = now.Format(defaultTimestampFormat)
+	}
+	if duration, err := computeDuration(startTime, endTime); err == nil {
+		status.Duration = duration.Seconds()
+	}
+
+	return status
+}
+
+// publishEndEvent returns the PublishEnd event logged by the monitoring for the transaction, if any.
+func publishEndEvent(tx transactionEvent) (publishEvent, bool) {
+	for _, event := range tx.Events {
+		if event.Event == endEvent && event.MonitoringEvent == "true" {
+			return event, true
+		}
+	}
+	return publishEvent{}, false
+}
+
+// closedState determines the state of a closed transaction from its PublishEnd event;
+// superseded transactions are the only ones closed without validity information.
+func closedState(end publishEvent) string {
+	switch {
+	case end.Outcome == timeoutOutcome:
+		return timedOutState
+	case end.Outcome == failedOutcome:
+		return failedState
+	case end.IsValid == "true":
+		return completedState
+	case end.IsValid == "false":
+		return invalidState
+	default:
+		return supersededState
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_transactionStatus(t *testing.T) {
+	now, _ := time.Parse(defaultTimestampFormat, "2017-09-22T12:00:00Z")
+	annotations := contentTypeRegistry[annotationsContentType]
+	annotations.timeout = 30 * time.Minute
+
+	var tests = []struct {
+		name        string
+		events      []publishEvent
+		expState    string
+		expClosed   bool
+		expEndTime  string
+		expDuration float64
+	}{
+		{
+			name: "open",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+				{Event: mapperEvent, Time: "2017-09-22T11:50:05Z", IsValid: "true"},
+			},
+			expState:    openState,
+			expDuration: 600,
+		},
+		{
+			name: "timed out, not yet closed",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:00:00Z"},
+			},
+			expState:    timedOutState,
+			expDuration: 3600,
+		},
+		{
+			name: "completed, not yet closed",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+				{Event: mapperEvent, Time: "2017-09-22T11:50:05Z", IsValid: "true"},
+				{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:10Z", Level: infoLevel},
+			},
+			expState:    completedState,
+			expEndTime:  "2017-09-22T11:50:10Z",
+			expDuration: 10,
+		},
+		{
+			name: "invalid",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+				{Event: mapperEvent, Time: "2017-09-22T11:50:02Z", IsValid: "false"},
+			},
+			expState:    invalidState,
+			expEndTime:  "2017-09-22T11:50:02Z",
+			expDuration: 2,
+		},
+		{
+			name: "failed",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+				{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:04Z", Level: errorLevel},
+			},
+			expState:    failedState,
+			expEndTime:  "2017-09-22T11:50:04Z",
+			expDuration: 4,
+		},
+		{
+			name: "closed as superseded",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+				{Event: endEvent, Time: "2017-09-22T11:55:00Z", MonitoringEvent: "true"},
+			},
+			expState:    supersededState,
+			expClosed:   true,
+			expEndTime:  "2017-09-22T11:55:00Z",
+			expDuration: 300,
+		},
+		{
+			name: "closed as timed out",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:00:00Z"},
+				{Event: endEvent, Time: "2017-09-22T11:30:00Z", MonitoringEvent: "true", IsValid: timeoutOutcome, Outcome: timeoutOutcome},
+			},
+			expState:    timedOutState,
+			expClosed:   true,
+			expEndTime:  "2017-09-22T11:30:00Z",
+			expDuration: 1800,
+		},
+		{
+			name: "closed as completed",
+			events: []publishEvent{
+				{Event: startEvent, Time: "2017-09-22T11:50:00Z"},
+				{Event: completenessCriteriaEvent, Time: "2017-09-22T11:50:10Z", Level: infoLevel},
+				{Event: endEvent, Time: "2017-09-22T11:50:10Z", MonitoringEvent: "true", IsValid: "true"},
+			},
+			expState:    completedState,
+			expClosed:   true,
+			expEndTime:  "2017-09-22T11:50:10Z",
+			expDuration: 10,
+		},
+	}
+
+	s := AnnotationsMonitoringService{contentType: annotations}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := s.transactionStatus(transactionEvent{TransactionID: "tid_1", UUID: "uuid_1", Events: test.events}, now)
+
+			assert.Equal(t, test.expState, status.State)
+			assert.Equal(t, test.expClosed, status.Closed)
+			assert.Equal(t, test.expEndTime, status.EndTime)
+			assert.Equal(t, test.expDuration, status.Duration)
+			assert.Equal(t, annotationsContentType, status.ContentType)
+			assert.Len(t, status.Events, len(test.events))
+		})
+	}
+}